@@ -0,0 +1,749 @@
+// Package bump implements changing PORTREVISION and PORTEPOCH in port Makefiles.
+package bump
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+var (
+	distversionRe = lineRe("DISTVERSION")
+	portversionRe = lineRe("PORTVERSION")
+	// DISTVERSIONPREFIX or DISTVERSIONSUFFIX at the start of buf
+	distversionAffixRe = regexp.MustCompile(`\A[ \t]*DISTVERSION(?:PREFIX|SUFFIX)[ \t]*\??=.*(?:\n|\z)`)
+	categoriesRe       = lineRe("CATEGORIES")
+)
+
+// lineRe returns a regexp matching whole name assignment lines, with
+// submatches for indentation, space before the assignment operator, the
+// operator and space after it. Operator is =, ?= or :=.
+func lineRe(name string) *regexp.Regexp {
+	return regexp.MustCompile(`(?m)^([ \t]*)` + name + `([ \t]*)([?:]?=)([ \t]*).*(?:\n|\z)`)
+}
+
+// valueRe returns a regexp matching name assignment lines, with submatches
+// for everything before the value, the value and the rest of the line. Value
+// ends at whitespace or at the start of a trailing comment. Operator is =, ?=,
+// := or +=, which is matched only to be refused by checkAppend.
+func valueRe(name string) *regexp.Regexp {
+	return regexp.MustCompile(`(?m)^([ \t]*` + name + `[ \t]*[?:+]?=[ \t]*)([^\s#]+)(.*(?:\n|\z))`)
+}
+
+// variable describes a numeric Makefile variable Bump can change.
+type variable struct {
+	name    string
+	valueRe *regexp.Regexp
+	lineRe  *regexp.Regexp
+	// variable lines to insert a missing definition after, in order of
+	// preference, before falling back to DISTVERSION and PORTVERSION
+	after []*regexp.Regexp
+	// per-flavor definitions, like PORTREVISION_py39
+	flavors *variable
+}
+
+var (
+	portrevision = variable{
+		name:    "PORTREVISION",
+		valueRe: valueRe("PORTREVISION"),
+		lineRe:  lineRe("PORTREVISION"),
+		flavors: &variable{
+			name:    "PORTREVISION_<flavor>",
+			valueRe: valueRe(`PORTREVISION_[A-Za-z0-9_]+`),
+		},
+	}
+	portepoch = variable{
+		name:    "PORTEPOCH",
+		valueRe: valueRe("PORTEPOCH"),
+		lineRe:  lineRe("PORTEPOCH"),
+		after:   []*regexp.Regexp{portrevision.lineRe},
+		flavors: &variable{
+			name:    "PORTEPOCH_<flavor>",
+			valueRe: valueRe(`PORTEPOCH_[A-Za-z0-9_]+`),
+		},
+	}
+)
+
+// Existing values above this are most likely a mistake, like a timestamp,
+// and are refused.
+const maxRevision = 10000
+
+// Options describes a PORTREVISION or PORTEPOCH change.
+type Options struct {
+	// Amount to adjust PORTREVISION by
+	Delta int
+	// Set PORTREVISION to Value instead of adjusting it
+	Set   bool
+	Value uint64
+	// Change all PORTREVISION definitions instead of refusing to touch
+	// Makefiles that have more than one
+	All bool
+	// Leave Makefiles alone if the new PORTREVISION would exceed Max,
+	// 0 means no limit
+	Max uint64
+	// Add a literal PORTREVISION after one computed from make variables
+	// instead of refusing to touch it
+	Force bool
+	// Change PORTEPOCH instead of PORTREVISION
+	Epoch bool
+	// Remove all PORTREVISION definitions, whatever their values, instead of
+	// changing PORTREVISION or in addition to changing PORTEPOCH
+	ResetRevision bool
+	// Insert missing definitions before the first definition of variable
+	// Before, if there is one, instead of after DISTVERSION or PORTVERSION
+	Before string
+	// Template for inserted lines, executed with TemplateData, instead of
+	// mirroring the line they are inserted after
+	Template *template.Template
+	// Also change per-flavor definitions, like PORTREVISION_py39, the same
+	// way, they are never added
+	Flavors bool
+	// Comment to put on changed and inserted lines, after StampMarker, an
+	// existing stamp comment is replaced
+	Stamp string
+	// Width of inserted lines before the value, padded with tabs and spaces
+	// where tabs don't fit, instead of mirroring the line they are inserted
+	// after, 0 means mirror
+	Align int
+}
+
+// StampMarker starts Options.Stamp comments, telling them apart from other
+// comments.
+const StampMarker = "# portbump: "
+
+// TemplateData is passed to Options.Template to build an inserted line.
+type TemplateData struct {
+	// Variable name, PORTREVISION or PORTEPOCH
+	Name  string
+	Value uint64
+}
+
+// Target returns the name of the variable changed according to opts.
+func (opts Options) Target() string {
+	if opts.Epoch {
+		return portepoch.name
+	}
+	return portrevision.name
+}
+
+// apply returns value rev of variable v changed according to opts.
+func (opts Options) apply(v variable, rev uint64) (uint64, error) {
+	if opts.Set {
+		return opts.Value, nil
+	}
+	if opts.Delta < 0 && rev < uint64(-opts.Delta) {
+		return 0, fmt.Errorf("cannot decrement %s %d", v.name, rev)
+	}
+	return uint64(int64(rev) + int64(opts.Delta)), nil
+}
+
+// Action is what Bump did to PORTREVISION or PORTEPOCH.
+type Action int
+
+const (
+	Skipped Action = iota
+	Bumped
+	Added
+	Removed
+	// Value was left alone because the new one exceeds Options.Max
+	Capped
+	// PORTREVISION was removed by Options.ResetRevision
+	Reset
+)
+
+// Changed reports whether a Makefile was modified.
+func (a Action) Changed() bool {
+	return a != Skipped && a != Capped
+}
+
+func (a Action) String() string {
+	switch a {
+	case Skipped:
+		return "skipped"
+	case Bumped:
+		return "bumped"
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Capped:
+		return "capped"
+	case Reset:
+		return "reset"
+	default:
+		panic("unknown action: " + strconv.Itoa(int(a)))
+	}
+}
+
+// Result describes a change made by Bump.
+type Result struct {
+	// Resulting Makefile content, src itself if nothing was changed
+	Buf    []byte
+	Action Action
+	// PORTREVISION, or PORTEPOCH with Options.Epoch, before and after
+	// the change
+	OldRevision uint64
+	NewRevision uint64
+}
+
+// Bump applies opts to PORTREVISION in Makefile content src, inserting
+// PORTREVISION after DISTVERSION or PORTVERSION if it's missing.
+// PORTREVISION is removed when its new value is 0. Makefile is left alone
+// if the new value exceeds opts.Max.
+//
+// With opts.Epoch, PORTEPOCH is changed the same way instead and is inserted
+// after PORTREVISION, if there is one. With opts.ResetRevision, PORTREVISION
+// is removed, before changing PORTEPOCH if opts.Epoch is set too. With
+// opts.Flavors, existing per-flavor definitions, like PORTREVISION_py39, are
+// changed along with the variable.
+//
+// Changed Makefile content always ends with a newline. Makefiles with
+// CRLF line endings keep them, including on added lines, as do ones
+// starting with a UTF-8 byte order mark.
+func Bump(src []byte, opts Options) (Result, error) {
+	orig := src
+	src, hasBOM := cutBOM(src)
+	dos := isCRLF(src)
+	if dos {
+		src = bytes.ReplaceAll(src, crlf, lf)
+	}
+
+	res, err := bump(src, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	if !res.Action.Changed() {
+		res.Buf = orig
+		return res, nil
+	}
+	if n := len(res.Buf); n > 0 && res.Buf[n-1] != '\n' {
+		res.Buf = append(res.Buf, '\n')
+	}
+	if dos {
+		res.Buf = bytes.ReplaceAll(res.Buf, lf, crlf)
+	}
+	if hasBOM {
+		res.Buf = append(append([]byte{}, bom...), res.Buf...)
+	}
+	return res, nil
+}
+
+var (
+	crlf = []byte("\r\n")
+	lf   = []byte("\n")
+	bom  = []byte("\xef\xbb\xbf")
+)
+
+// cutBOM returns buf without a leading UTF-8 byte order mark and whether
+// there was one. Lines are matched from their start, so a BOM would hide the
+// first line, and the DISTVERSION or PORTVERSION anchor on it.
+func cutBOM(buf []byte) ([]byte, bool) {
+	if bytes.HasPrefix(buf, bom) {
+		return buf[len(bom):], true
+	}
+	return buf, false
+}
+
+// isCRLF reports whether all lines in buf end with CRLF. Variable lines are
+// only matched with LF endings, so CRLF ones are converted before and after
+// changing them.
+func isCRLF(buf []byte) bool {
+	n := bytes.Count(buf, lf)
+	return n > 0 && bytes.Count(buf, crlf) == n
+}
+
+func bump(src []byte, opts Options) (Result, error) {
+	if !opts.Epoch {
+		if opts.ResetRevision {
+			return removeVar(src, portrevision, opts), nil
+		}
+		res, err := bumpVar(src, portrevision, opts)
+		if err != nil {
+			return Result{}, err
+		}
+		return bumpFlavors(src, res, portrevision, opts)
+	}
+
+	orig := src
+	if opts.ResetRevision {
+		src = removeVar(src, portrevision, opts).Buf
+	}
+	res, err := bumpVar(src, portepoch, opts)
+	if err == nil {
+		res, err = bumpFlavors(src, res, portepoch, opts)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	if !res.Action.Changed() {
+		// don't remove PORTREVISION without changing PORTEPOCH
+		res.Buf = orig
+	}
+	return res, nil
+}
+
+// Current returns the value of PORTREVISION, or PORTEPOCH with opts.Epoch,
+// in Makefile content src, 0 if it's not defined. With opts.All the first
+// of multiple definitions is returned.
+func Current(src []byte, opts Options) (uint64, error) {
+	v := portrevision
+	if opts.Epoch {
+		v = portepoch
+	}
+	src, _ = cutBOM(src)
+	if isCRLF(src) {
+		src = bytes.ReplaceAll(src, crlf, lf)
+	}
+
+	ms := findAssignments(v.valueRe, src)
+	if ms == nil {
+		return 0, nil
+	}
+	if err := checkAppend(src, v, ms); err != nil {
+		return 0, err
+	}
+	if len(ms) > 1 && !opts.All {
+		return 0, fmt.Errorf("multiple %s definitions", v.name)
+	}
+	value := src[ms[0][4]:ms[0][5]]
+	if isComputed(value) {
+		return 0, fmt.Errorf("%s is computed from a variable", v.name)
+	}
+	rev, err := strconv.ParseUint(string(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("not a numeric %s", v.name)
+	}
+	return rev, nil
+}
+
+// bumpVar applies opts to variable v in Makefile content src.
+func bumpVar(src []byte, v variable, opts Options) (Result, error) {
+	if ms := findAssignments(v.valueRe, src); ms != nil {
+		if err := checkAppend(src, v, ms); err != nil {
+			return Result{}, err
+		}
+		if len(ms) > 1 && !opts.All {
+			return Result{}, fmt.Errorf("multiple %s definitions", v.name)
+		}
+
+		if len(ms) == 1 && opts.Force && isComputed(src[ms[0][4]:ms[0][5]]) {
+			return forceVar(src, v, ms[0], opts)
+		}
+		return bumpAssignments(src, v, ms, opts)
+	}
+
+	rev := uint64(opts.Delta)
+	if opts.Set {
+		rev = opts.Value
+	} else if opts.Delta < 0 {
+		// nothing to decrement
+		return Result{Buf: src}, nil
+	}
+	if rev == 0 {
+		return Result{Buf: src}, nil
+	}
+	if opts.Max > 0 && rev > opts.Max {
+		return Result{src, Capped, 0, rev}, nil
+	}
+
+	var m []int
+	var pos int
+	for _, re := range v.after {
+		if ms := findAssignments(re, src); ms != nil {
+			m, pos = ms[0], skipContinued(src, ms[0][1])
+			break
+		}
+	}
+	if m == nil {
+		ms := findAssignments(distversionRe, src)
+		if ms == nil {
+			ms = findAssignments(portversionRe, src)
+		}
+		if ms == nil {
+			return Result{Buf: src}, nil
+		}
+		m, pos = ms[0], skipContinued(src, ms[0][1])
+		// the variable goes after DISTVERSIONPREFIX/DISTVERSIONSUFFIX, if any
+		for {
+			am := distversionAffixRe.FindIndex(src[pos:])
+			if am == nil || am[1] == 0 {
+				break
+			}
+			pos = skipContinued(src, pos+am[1])
+		}
+	}
+	// ports without a version still don't get the variable with opts.Before
+	if opts.Before != "" {
+		if ms := findAssignments(anchorRe(opts.Before), src); ms != nil {
+			m, pos = ms[0], ms[0][0]
+		}
+	}
+
+	buf, err := opts.insert(src, pos, m, v.name, rev)
+	return Result{buf, Added, 0, rev}, err
+}
+
+// bumpAssignments applies opts to definitions of variable v in Makefile
+// content src matched by ms.
+func bumpAssignments(src []byte, v variable, ms [][]int, opts Options) (Result, error) {
+	var res Result
+	buf := make([]byte, 0, len(src)+len(ms))
+	var pos int
+	for i, m := range ms {
+		if isComputed(src[m[4]:m[5]]) {
+			return Result{}, fmt.Errorf("%s is computed from a variable, not bumping", v.name)
+		}
+		rev, err := strconv.ParseUint(string(src[m[4]:m[5]]), 10, 64)
+		if err != nil {
+			if err.(*strconv.NumError).Err == strconv.ErrSyntax {
+				return Result{}, fmt.Errorf("not a numeric %s", v.name)
+			}
+			// out of range
+			rev = maxRevision + 1
+		}
+		if rev > maxRevision {
+			return Result{}, fmt.Errorf("%s %s is unreasonably large, not bumping", v.name, src[m[4]:m[5]])
+		}
+
+		newRev, err := opts.apply(v, rev)
+		if err != nil {
+			return Result{}, err
+		}
+		if opts.Max > 0 && newRev > opts.Max {
+			return Result{src, Capped, rev, newRev}, nil
+		}
+		if i == 0 {
+			res = Result{nil, Bumped, rev, newRev}
+		}
+
+		buf = append(buf, src[pos:m[0]]...)
+		switch newRev {
+		case rev:
+			buf = append(buf, src[m[0]:m[1]]...)
+		case 0:
+			// drop the whole line
+		default:
+			buf = append(buf, src[m[2]:m[3]]...)
+			buf = strconv.AppendUint(buf, newRev, 10)
+			buf = opts.appendStamped(buf, src[m[6]:m[7]])
+		}
+		pos = m[1]
+	}
+	buf = append(buf, src[pos:]...)
+
+	switch {
+	case bytes.Equal(buf, src):
+		res.Action = Skipped
+		buf = src
+	case res.NewRevision == 0:
+		res.Action = Removed
+	}
+	res.Buf = buf
+	return res, nil
+}
+
+// bumpFlavors applies opts to per-flavor definitions of variable v in res.Buf,
+// the result of changing v in Makefile content src, with opts.Flavors.
+func bumpFlavors(src []byte, res Result, v variable, opts Options) (Result, error) {
+	if !opts.Flavors || res.Action == Capped {
+		return res, nil
+	}
+	ms := findAssignments(v.flavors.valueRe, res.Buf)
+	if ms == nil {
+		return res, nil
+	}
+	if err := checkAppend(res.Buf, *v.flavors, ms); err != nil {
+		return Result{}, err
+	}
+
+	fres, err := bumpAssignments(res.Buf, *v.flavors, ms, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	switch {
+	case fres.Action == Capped:
+		fres.Buf = src
+		return fres, nil
+	case !res.Action.Changed():
+		// only per-flavor definitions were changed
+		return fres, nil
+	}
+	res.Buf = fres.Buf
+	return res, nil
+}
+
+// compiled Options.Before regexps
+var anchorRes sync.Map
+
+// anchorRe returns lineRe of variable name, caching it for other ports.
+func anchorRe(name string) *regexp.Regexp {
+	if re, ok := anchorRes.Load(name); ok {
+		return re.(*regexp.Regexp)
+	}
+	re, _ := anchorRes.LoadOrStore(name, lineRe(regexp.QuoteMeta(name)))
+	return re.(*regexp.Regexp)
+}
+
+// removeVar removes all definitions of variable v, and with opts.Flavors its
+// per-flavor definitions, from Makefile content src. Result.OldRevision is
+// the first definition value, if it's numeric.
+func removeVar(src []byte, v variable, opts Options) Result {
+	ms := findAssignments(v.valueRe, src)
+	if opts.Flavors {
+		ms = append(ms, findAssignments(v.flavors.valueRe, src)...)
+		sort.Slice(ms, func(i, j int) bool { return ms[i][0] < ms[j][0] })
+	}
+	if ms == nil {
+		return Result{Buf: src}
+	}
+
+	old, _ := strconv.ParseUint(string(src[ms[0][4]:ms[0][5]]), 10, 64)
+	buf := make([]byte, 0, len(src))
+	var pos int
+	for _, m := range ms {
+		buf = append(buf, src[pos:m[0]]...)
+		pos = m[1]
+	}
+	buf = append(buf, src[pos:]...)
+
+	return Result{buf, Reset, old, 0}
+}
+
+// checkAppend returns an error if any of v definitions in src matched by ms
+// appends to it with +=, which makes no sense for a number.
+func checkAppend(src []byte, v variable, ms [][]int) error {
+	for _, m := range ms {
+		// valueRe's first submatch ends with the operator and space after it
+		prefix := bytes.TrimRight(src[m[2]:m[3]], " \t")
+		if bytes.HasSuffix(prefix, []byte("+=")) {
+			return fmt.Errorf("%s is appended to with +=, not bumping", v.name)
+		}
+	}
+	return nil
+}
+
+// isComputed reports whether variable value refers to make variables.
+func isComputed(value []byte) bool {
+	return bytes.IndexByte(value, '$') >= 0
+}
+
+// forceVar adds a literal definition of v after the computed one matched by
+// m, overriding it.
+func forceVar(src []byte, v variable, m []int, opts Options) (Result, error) {
+	rev := uint64(opts.Delta)
+	if opts.Set {
+		rev = opts.Value
+	}
+	if !opts.Set && opts.Delta < 0 || rev == 0 {
+		// the computed value can't be decremented or removed
+		return Result{}, fmt.Errorf("%s is computed from a variable, not bumping", v.name)
+	}
+	if opts.Max > 0 && rev > opts.Max {
+		return Result{src, Capped, 0, rev}, nil
+	}
+
+	lm := v.lineRe.FindSubmatchIndex(src[m[0]:])
+	for i := range lm {
+		lm[i] += m[0]
+	}
+	buf, err := opts.insert(src, m[1], lm, v.name, rev)
+	return Result{buf, Added, 0, rev}, err
+}
+
+// CheckPlacement returns an error if the first PORTREVISION, or PORTEPOCH
+// with opts.Epoch, definition in Makefile content src isn't where ports
+// conventionally have it, after DISTVERSION or PORTVERSION and before
+// CATEGORIES. Makefiles without a definition, or without the lines to compare
+// its position to, pass the check.
+func CheckPlacement(src []byte, opts Options) error {
+	v := portrevision
+	if opts.Epoch {
+		v = portepoch
+	}
+	src, _ = cutBOM(src)
+	if isCRLF(src) {
+		src = bytes.ReplaceAll(src, crlf, lf)
+	}
+
+	ms := findAssignments(v.valueRe, src)
+	if ms == nil {
+		return nil
+	}
+	pos := ms[0][0]
+	for _, a := range []struct {
+		name string
+		re   *regexp.Regexp
+	}{
+		{"DISTVERSION", distversionRe},
+		{"PORTVERSION", portversionRe},
+	} {
+		if m := findAssignments(a.re, src); m != nil {
+			if m[0][0] > pos {
+				return fmt.Errorf("%s is defined before %s", v.name, a.name)
+			}
+			break
+		}
+	}
+	if m := findAssignments(categoriesRe, src); m != nil && m[0][0] < pos {
+		return fmt.Errorf("%s is defined after CATEGORIES", v.name)
+	}
+	return nil
+}
+
+// Stream reads Makefile content from r, applies opts to it with Bump and
+// writes the result to w, but only if it was changed.
+func Stream(r io.Reader, w io.Writer, opts Options) (Result, error) {
+	var src bytes.Buffer
+	if _, err := src.ReadFrom(r); err != nil {
+		return Result{}, err
+	}
+
+	res, err := Bump(src.Bytes(), opts)
+	if err != nil {
+		return Result{}, err
+	}
+	if res.Action.Changed() {
+		if _, err := w.Write(res.Buf); err != nil {
+			return Result{}, err
+		}
+	}
+	return res, nil
+}
+
+// findAssignments returns submatch indexes of all re matches in buf, except
+// for ones on lines continuing the previous line with a backslash, which are
+// part of a comment or of another variable value.
+func findAssignments(re *regexp.Regexp, buf []byte) [][]int {
+	var res [][]int
+	for _, m := range re.FindAllSubmatchIndex(buf, -1) {
+		if m[0] >= 2 && buf[m[0]-1] == '\n' && buf[m[0]-2] == '\\' {
+			continue
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+// insert inserts name=rev at pos, formatted with opts.Template or like the
+// line matched by m.
+func (opts Options) insert(buf []byte, pos int, m []int, name string, rev uint64) ([]byte, error) {
+	if opts.Template == nil {
+		line := assignment(buf, m, name, rev, opts.Align)
+		return insertLine(buf, pos, opts.appendStamped(line[:len(line)-1], lf)), nil
+	}
+
+	var sb strings.Builder
+	if err := opts.Template.Execute(&sb, TemplateData{name, rev}); err != nil {
+		return nil, err
+	}
+	line := strings.TrimSuffix(sb.String(), "\n")
+	return insertLine(buf, pos, opts.appendStamped([]byte(line), lf)), nil
+}
+
+// appendStamped appends rest of a variable line, following its value, to
+// buf, with the opts.Stamp comment added or replacing an existing one.
+func (opts Options) appendStamped(buf, rest []byte) []byte {
+	if opts.Stamp == "" {
+		return append(buf, rest...)
+	}
+
+	eol := bytes.HasSuffix(rest, lf)
+	rest = bytes.TrimSuffix(rest, lf)
+	if i := bytes.Index(rest, []byte(StampMarker)); i >= 0 {
+		buf = append(buf, rest[:i]...)
+	} else {
+		buf = append(buf, bytes.TrimRight(rest, " \t")...)
+		buf = append(buf, ' ')
+	}
+	buf = append(buf, StampMarker...)
+	buf = append(buf, opts.Stamp...)
+	if eol {
+		buf = append(buf, '\n')
+	}
+	return buf
+}
+
+// skipContinued returns the position after lines continuing the line
+// ending at pos with a backslash, pos itself if there are none.
+func skipContinued(buf []byte, pos int) int {
+	for pos >= 2 && pos < len(buf) && buf[pos-1] == '\n' && buf[pos-2] == '\\' {
+		i := bytes.IndexByte(buf[pos:], '\n')
+		if i < 0 {
+			return len(buf)
+		}
+		pos += i + 1
+	}
+	return pos
+}
+
+// insertLine inserts line at pos, which is at the start of a line or at the
+// end of buf.
+func insertLine(buf []byte, pos int, line []byte) []byte {
+	res := make([]byte, 0, len(buf)+len(line)+1)
+	res = append(res, buf[:pos]...)
+	if pos > 0 && buf[pos-1] != '\n' {
+		// the variable goes after the last line and it's missing a newline
+		res = append(res, '\n')
+	}
+	res = append(res, line...)
+	return append(res, buf[pos:]...)
+}
+
+// assignment returns a name=rev line mirroring indentation, assignment
+// operator and value alignment of the line matched by m. With align > 0, the
+// value is put at column align instead, or a tab after the operator if the
+// line is already wider.
+func assignment(buf []byte, m []int, name string, rev uint64, align int) []byte {
+	var line []byte
+	line = append(line, buf[m[2]:m[3]]...)
+	line = append(line, name...)
+	line = append(line, buf[m[4]:m[5]]...)
+	line = append(line, buf[m[6]:m[7]]...)
+	if align > 0 {
+		if textWidth(line) >= align {
+			line = append(line, '\t')
+		}
+		for w := textWidth(line); w < align; w = textWidth(line) {
+			if w+8-w%8 <= align {
+				line = append(line, '\t')
+			} else {
+				line = append(line, ' ')
+			}
+		}
+		line = strconv.AppendUint(line, rev, 10)
+		return append(line, '\n')
+	}
+	if sep := buf[m[8]:m[9]]; len(sep) > 0 {
+		// pad to the value column, using tabs if the matched line does
+		col := textWidth(buf[m[0]:m[9]])
+		pad := byte(' ')
+		if bytes.IndexByte(sep, '\t') >= 0 {
+			pad = '\t'
+		}
+		line = append(line, pad)
+		for textWidth(line) < col {
+			line = append(line, pad)
+		}
+	}
+	line = strconv.AppendUint(line, rev, 10)
+	return append(line, '\n')
+}
+
+// textWidth returns the display width of b assuming 8 column tab stops.
+func textWidth(b []byte) int {
+	var w int
+	for _, c := range b {
+		if c == '\t' {
+			w += 8 - w%8
+		} else {
+			w++
+		}
+	}
+	return w
+}
@@ -0,0 +1,495 @@
+package bump
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestBump(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		opts   Options
+		want   string
+		action Action
+		err    string
+	}{
+		{
+			name:   "bump",
+			src:    "PORTVERSION=\t1.0\nPORTREVISION=\t1\n",
+			opts:   Options{Delta: 1},
+			want:   "PORTVERSION=\t1.0\nPORTREVISION=\t2\n",
+			action: Bumped,
+		},
+		{
+			name:   "bump by amount",
+			src:    "PORTVERSION=\t1.0\nPORTREVISION=\t1\n",
+			opts:   Options{Delta: 3},
+			want:   "PORTVERSION=\t1.0\nPORTREVISION=\t4\n",
+			action: Bumped,
+		},
+		{
+			name:   "set",
+			src:    "PORTVERSION=\t1.0\nPORTREVISION=\t1\n",
+			opts:   Options{Set: true, Value: 5},
+			want:   "PORTVERSION=\t1.0\nPORTREVISION=\t5\n",
+			action: Bumped,
+		},
+		{
+			name:   "decrement to 0 removes",
+			src:    "PORTVERSION=\t1.0\nPORTREVISION=\t1\nCATEGORIES=\tx\n",
+			opts:   Options{Delta: -1},
+			want:   "PORTVERSION=\t1.0\nCATEGORIES=\tx\n",
+			action: Removed,
+		},
+		{
+			name:   "nothing to decrement",
+			src:    "PORTVERSION=\t1.0\n",
+			opts:   Options{Delta: -1},
+			want:   "PORTVERSION=\t1.0\n",
+			action: Skipped,
+		},
+		{
+			name: "cannot decrement below 0",
+			src:  "PORTVERSION=\t1.0\nPORTREVISION=\t1\n",
+			opts: Options{Delta: -2},
+			err:  "cannot decrement PORTREVISION 1",
+		},
+		{
+			name:   "no version",
+			src:    "PORTNAME=\tx\n",
+			opts:   Options{Delta: 1},
+			want:   "PORTNAME=\tx\n",
+			action: Skipped,
+		},
+		{
+			name: "guarded duplicates",
+			src:  "PORTVERSION=\t1.0\n.if ${FLAVOR} == a\nPORTREVISION=\t1\n.else\nPORTREVISION=\t3\n.endif\n",
+			opts: Options{Delta: 1},
+			err:  "multiple PORTREVISION definitions",
+		},
+		{
+			name:   "guarded duplicates with All",
+			src:    "PORTVERSION=\t1.0\n.if ${FLAVOR} == a\nPORTREVISION=\t1\n.else\nPORTREVISION=\t3\n.endif\n",
+			opts:   Options{Delta: 1, All: true},
+			want:   "PORTVERSION=\t1.0\n.if ${FLAVOR} == a\nPORTREVISION=\t2\n.else\nPORTREVISION=\t4\n.endif\n",
+			action: Bumped,
+		},
+		{
+			name:   "add after DISTVERSION with tabs",
+			src:    "PORTNAME=\tx\nDISTVERSION=\t1.0\nCATEGORIES=\twww\n",
+			opts:   Options{Delta: 1},
+			want:   "PORTNAME=\tx\nDISTVERSION=\t1.0\nPORTREVISION=\t1\nCATEGORIES=\twww\n",
+			action: Added,
+		},
+		{
+			name:   "add after PORTVERSION with a space",
+			src:    "PORTVERSION= 1.0\n",
+			opts:   Options{Delta: 1},
+			want:   "PORTVERSION= 1.0\nPORTREVISION= 1\n",
+			action: Added,
+		},
+		{
+			name:   "add after PORTVERSION aligned with spaces",
+			src:    "PORTVERSION=    1.0\n",
+			opts:   Options{Delta: 1},
+			want:   "PORTVERSION=    1.0\nPORTREVISION=   1\n",
+			action: Added,
+		},
+		{
+			name:   "add with indentation and space before the operator",
+			src:    "  PORTVERSION ?=\t1.0\n",
+			opts:   Options{Delta: 1},
+			want:   "  PORTVERSION ?=\t1.0\n  PORTREVISION ?=\t1\n",
+			action: Added,
+		},
+		{
+			name:   "add after DISTVERSIONPREFIX and DISTVERSIONSUFFIX",
+			src:    "DISTVERSION=\t1.0\nDISTVERSIONPREFIX=\tv\nDISTVERSIONSUFFIX=\t-rc1\nCATEGORIES=\tx\n",
+			opts:   Options{Delta: 1},
+			want:   "DISTVERSION=\t1.0\nDISTVERSIONPREFIX=\tv\nDISTVERSIONSUFFIX=\t-rc1\nPORTREVISION=\t1\nCATEGORIES=\tx\n",
+			action: Added,
+		},
+		{
+			name:   "add after DISTVERSION following DISTVERSIONPREFIX",
+			src:    "DISTVERSIONPREFIX=\tv\nDISTVERSION=\t1.0\nCATEGORIES=\tx\n",
+			opts:   Options{Delta: 1},
+			want:   "DISTVERSIONPREFIX=\tv\nDISTVERSION=\t1.0\nPORTREVISION=\t1\nCATEGORIES=\tx\n",
+			action: Added,
+		},
+		{
+			name:   "trailing comment",
+			src:    "PORTREVISION=\t1 # c\n",
+			opts:   Options{Delta: 1},
+			want:   "PORTREVISION=\t2 # c\n",
+			action: Bumped,
+		},
+		{
+			name:   "trailing comment without space",
+			src:    "PORTREVISION=\t1#c\n",
+			opts:   Options{Delta: 1},
+			want:   "PORTREVISION=\t2#c\n",
+			action: Bumped,
+		},
+		{
+			name:   "commented out definition",
+			src:    "PORTVERSION=\t1.0\n#PORTREVISION=\t1\n",
+			opts:   Options{Delta: 1},
+			want:   "PORTVERSION=\t1.0\nPORTREVISION=\t1\n#PORTREVISION=\t1\n",
+			action: Added,
+		},
+		{
+			name:   "definition on a continued line",
+			src:    "PORTVERSION=\t1.0\nCOMMENT=\tfoo \\\nPORTREVISION=\t3\n",
+			opts:   Options{Delta: 1},
+			want:   "PORTVERSION=\t1.0\nPORTREVISION=\t1\nCOMMENT=\tfoo \\\nPORTREVISION=\t3\n",
+			action: Added,
+		},
+		{
+			name:   "capped",
+			src:    "PORTVERSION=\t1.0\nPORTREVISION=\t3\n",
+			opts:   Options{Delta: 1, Max: 3},
+			want:   "PORTVERSION=\t1.0\nPORTREVISION=\t3\n",
+			action: Capped,
+		},
+		{
+			name: "computed",
+			src:  "PORTREVISION=\t${X}\n",
+			opts: Options{Delta: 1},
+			err:  "computed from a variable",
+		},
+		{
+			name: "computed with parentheses",
+			src:  "PORTREVISION=\t$(X)\n",
+			opts: Options{Delta: 1},
+			err:  "computed from a variable",
+		},
+		{
+			name:   "computed with Force",
+			src:    "PORTREVISION=\t${X}\n",
+			opts:   Options{Delta: 1, Force: true},
+			want:   "PORTREVISION=\t${X}\nPORTREVISION=\t1\n",
+			action: Added,
+		},
+		{
+			name:   "computed with parentheses and Force",
+			src:    "PORTREVISION=\t$(X:S/a/b/)\n",
+			opts:   Options{Delta: 1, Force: true},
+			want:   "PORTREVISION=\t$(X:S/a/b/)\nPORTREVISION=\t1\n",
+			action: Added,
+		},
+		{
+			name: "computed removal with Force",
+			src:  "PORTREVISION=\t$(X)\n",
+			opts: Options{Delta: -1, Force: true},
+			err:  "computed from a variable",
+		},
+		{
+			name:   "large value",
+			src:    "PORTREVISION=\t10000\n",
+			opts:   Options{Delta: 1},
+			want:   "PORTREVISION=\t10001\n",
+			action: Bumped,
+		},
+		{
+			name: "unreasonably large value",
+			src:  "PORTREVISION=\t20000\n",
+			opts: Options{Delta: 1},
+			err:  "unreasonably large",
+		},
+		{
+			name: "out of range value",
+			src:  "PORTREVISION=\t99999999999999999999\n",
+			opts: Options{Delta: 1},
+			err:  "unreasonably large",
+		},
+		{
+			name: "not numeric",
+			src:  "PORTREVISION=\tx\n",
+			opts: Options{Delta: 1},
+			err:  "not a numeric PORTREVISION",
+		},
+		{
+			name:   "epoch added after PORTREVISION",
+			src:    "PORTVERSION=\t1.0\nPORTREVISION=\t2\n",
+			opts:   Options{Delta: 1, Epoch: true},
+			want:   "PORTVERSION=\t1.0\nPORTREVISION=\t2\nPORTEPOCH=\t1\n",
+			action: Added,
+		},
+		{
+			name:   "epoch bumped",
+			src:    "PORTVERSION=\t1.0\nPORTEPOCH=\t1\n",
+			opts:   Options{Delta: 1, Epoch: true},
+			want:   "PORTVERSION=\t1.0\nPORTEPOCH=\t2\n",
+			action: Bumped,
+		},
+		{
+			name:   "epoch with reset",
+			src:    "PORTVERSION=\t1.0\nPORTREVISION=\t2\n",
+			opts:   Options{Delta: 1, Epoch: true, ResetRevision: true},
+			want:   "PORTVERSION=\t1.0\nPORTEPOCH=\t1\n",
+			action: Added,
+		},
+		{
+			name:   "epoch capped with reset keeps PORTREVISION",
+			src:    "PORTVERSION=\t1.0\nPORTREVISION=\t2\nPORTEPOCH=\t1\n",
+			opts:   Options{Delta: 1, Epoch: true, ResetRevision: true, Max: 1},
+			want:   "PORTVERSION=\t1.0\nPORTREVISION=\t2\nPORTEPOCH=\t1\n",
+			action: Capped,
+		},
+		{
+			name:   "reset",
+			src:    "PORTVERSION=\t1.0\nPORTREVISION=\t2\nCATEGORIES=\tx\n",
+			opts:   Options{ResetRevision: true},
+			want:   "PORTVERSION=\t1.0\nCATEGORIES=\tx\n",
+			action: Reset,
+		},
+		{
+			name:   "reset without PORTREVISION",
+			src:    "PORTVERSION=\t1.0\n",
+			opts:   Options{ResetRevision: true},
+			want:   "PORTVERSION=\t1.0\n",
+			action: Skipped,
+		},
+		{
+			name:   "add without trailing newline",
+			src:    "PORTVERSION=\t1.0",
+			opts:   Options{Delta: 1},
+			want:   "PORTVERSION=\t1.0\nPORTREVISION=\t1\n",
+			action: Added,
+		},
+		{
+			name:   "bump without trailing newline",
+			src:    "PORTVERSION=\t1.0\nPORTREVISION=\t1",
+			opts:   Options{Delta: 1},
+			want:   "PORTVERSION=\t1.0\nPORTREVISION=\t2\n",
+			action: Bumped,
+		},
+		{
+			name:   "add with template",
+			src:    "PORTVERSION= 1.0\n",
+			opts:   Options{Delta: 1, Template: template.Must(template.New("").Parse(`{{.Name}}={{"\t"}}{{.Value}}`))},
+			want:   "PORTVERSION= 1.0\nPORTREVISION=\t1\n",
+			action: Added,
+		},
+		{
+			name:   "CRLF",
+			src:    "PORTVERSION=\t1.0\r\nCATEGORIES=\tx\r\n",
+			opts:   Options{Delta: 1},
+			want:   "PORTVERSION=\t1.0\r\nPORTREVISION=\t1\r\nCATEGORIES=\tx\r\n",
+			action: Added,
+		},
+		{
+			name:   "CRLF bump",
+			src:    "PORTREVISION=\t1\r\n",
+			opts:   Options{Delta: 1},
+			want:   "PORTREVISION=\t2\r\n",
+			action: Bumped,
+		},
+		{
+			name:   "add before variable",
+			src:    "PORTNAME=\tx\nPORTVERSION=\t1.0\nCATEGORIES=\tx\n",
+			opts:   Options{Delta: 1, Before: "CATEGORIES"},
+			want:   "PORTNAME=\tx\nPORTVERSION=\t1.0\nPORTREVISION=\t1\nCATEGORIES=\tx\n",
+			action: Added,
+		},
+		{
+			name:   "flavors left alone",
+			src:    "FLAVORS=\ta b\nPORTREVISION=\t1\nPORTREVISION_b=\t3\n",
+			opts:   Options{Delta: 1},
+			want:   "FLAVORS=\ta b\nPORTREVISION=\t2\nPORTREVISION_b=\t3\n",
+			action: Bumped,
+		},
+		{
+			name:   "flavors",
+			src:    "FLAVORS=\ta b\nPORTREVISION=\t1\nPORTREVISION_b=\t3\n",
+			opts:   Options{Delta: 1, Flavors: true},
+			want:   "FLAVORS=\ta b\nPORTREVISION=\t2\nPORTREVISION_b=\t4\n",
+			action: Bumped,
+		},
+		{
+			name:   "flavors only",
+			src:    "FLAVORS=\ta b\nPORTREVISION_b=\t3\n",
+			opts:   Options{Delta: 1, Flavors: true},
+			want:   "FLAVORS=\ta b\nPORTREVISION_b=\t4\n",
+			action: Bumped,
+		},
+		{
+			name:   "flavors reset",
+			src:    "PORTVERSION=\t1.0\nPORTREVISION=\t1\nPORTREVISION_b=\t3\n",
+			opts:   Options{ResetRevision: true, Flavors: true},
+			want:   "PORTVERSION=\t1.0\n",
+			action: Reset,
+		},
+		{
+			name:   "conditional assignment",
+			src:    "PORTREVISION?=\t1\n",
+			opts:   Options{Delta: 1},
+			want:   "PORTREVISION?=\t2\n",
+			action: Bumped,
+		},
+		{
+			name:   "immediate assignment",
+			src:    "PORTREVISION:=\t1\n",
+			opts:   Options{Delta: 1},
+			want:   "PORTREVISION:=\t2\n",
+			action: Bumped,
+		},
+		{
+			name: "append",
+			src:  "PORTREVISION+=\t1\n",
+			opts: Options{Delta: 1},
+			err:  "appended to with +=",
+		},
+		{
+			name:   "stamp",
+			src:    "PORTREVISION=\t1 # portbump: old\n",
+			opts:   Options{Delta: 1, Stamp: "new"},
+			want:   "PORTREVISION=\t2 # portbump: new\n",
+			action: Bumped,
+		},
+		{
+			name:   "add aligned",
+			src:    "PORTVERSION=\t1.0\n",
+			opts:   Options{Delta: 1, Align: 24},
+			want:   "PORTVERSION=\t1.0\nPORTREVISION=\t\t1\n",
+			action: Added,
+		},
+		{
+			name:   "BOM",
+			src:    "\xef\xbb\xbfDISTVERSION=\t1.0\nCATEGORIES=\tx\n",
+			opts:   Options{Delta: 1},
+			want:   "\xef\xbb\xbfDISTVERSION=\t1.0\nPORTREVISION=\t1\nCATEGORIES=\tx\n",
+			action: Added,
+		},
+		{
+			name:   "BOM and CRLF",
+			src:    "\xef\xbb\xbfPORTREVISION=\t1\r\n",
+			opts:   Options{Delta: 1},
+			want:   "\xef\xbb\xbfPORTREVISION=\t2\r\n",
+			action: Bumped,
+		},
+		{
+			name:   "add after continued DISTVERSION",
+			src:    "DISTVERSION=\t1.0 \\\n\t# foo\nCATEGORIES=\tx\n",
+			opts:   Options{Delta: 1},
+			want:   "DISTVERSION=\t1.0 \\\n\t# foo\nPORTREVISION=\t1\nCATEGORIES=\tx\n",
+			action: Added,
+		},
+		{
+			name:   "add after continued DISTVERSIONSUFFIX",
+			src:    "DISTVERSION=\t1.0\nDISTVERSIONSUFFIX=\t-a \\\n\t-b\nCATEGORIES=\tx\n",
+			opts:   Options{Delta: 1},
+			want:   "DISTVERSION=\t1.0\nDISTVERSIONSUFFIX=\t-a \\\n\t-b\nPORTREVISION=\t1\nCATEGORIES=\tx\n",
+			action: Added,
+		},
+		{
+			name:   "add after continued PORTREVISION",
+			src:    "PORTVERSION=\t1.0\nPORTREVISION=\t1 \\\n\t# foo\nCATEGORIES=\tx\n",
+			opts:   Options{Delta: 1, Epoch: true},
+			want:   "PORTVERSION=\t1.0\nPORTREVISION=\t1 \\\n\t# foo\nPORTEPOCH=\t1\nCATEGORIES=\tx\n",
+			action: Added,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Bump([]byte(tt.src), tt.opts)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Action != tt.action {
+				t.Errorf("got action %s, want %s", res.Action, tt.action)
+			}
+			if string(res.Buf) != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", res.Buf, tt.want)
+			}
+		})
+	}
+}
+
+func TestCurrent(t *testing.T) {
+	tests := []struct {
+		src  string
+		opts Options
+		want uint64
+		err  string
+	}{
+		{"PORTVERSION=\t1.0\n", Options{}, 0, ""},
+		{"PORTREVISION=\t3\n", Options{}, 3, ""},
+		{"PORTREVISION=\t3\nPORTEPOCH=\t1\n", Options{Epoch: true}, 1, ""},
+		{"PORTREVISION=\t1\nPORTREVISION=\t2\n", Options{}, 0, "multiple"},
+		{"PORTREVISION=\t1\nPORTREVISION=\t2\n", Options{All: true}, 1, ""},
+		{"PORTREVISION=\t${X}\n", Options{}, 0, "computed"},
+		{"PORTREVISION=\t3\r\n", Options{}, 3, ""},
+		{"PORTREVISION+=\t1\n", Options{}, 0, "appended"},
+		{"\xef\xbb\xbfPORTREVISION=\t3\r\n", Options{}, 3, ""},
+	}
+	for _, tt := range tests {
+		got, err := Current([]byte(tt.src), tt.opts)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got error %v, want %q", tt.src, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %d, want %d", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPlacement(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"DISTVERSION=\t1.0\nPORTREVISION=\t1\nCATEGORIES=\tx\n", ""},
+		{"PORTVERSION=\t1.0\nCATEGORIES=\tx\n", ""},
+		{"PORTREVISION=\t1\nDISTVERSION=\t1.0\nCATEGORIES=\tx\n", "before DISTVERSION"},
+		{"PORTVERSION=\t1.0\nCATEGORIES=\tx\nPORTREVISION=\t1\n", "after CATEGORIES"},
+	}
+	for _, tt := range tests {
+		err := CheckPlacement([]byte(tt.src), Options{})
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%q: %s", tt.src, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: got error %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	var w bytes.Buffer
+	res, err := Stream(strings.NewReader("PORTNAME=\tx\n"), &w, Options{Delta: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Action != Skipped || w.Len() != 0 {
+		t.Errorf("unchanged Makefile written: %s %q", res.Action, w.Bytes())
+	}
+
+	res, err = Stream(strings.NewReader("PORTREVISION=\t1\n"), &w, Options{Delta: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Action != Bumped || w.String() != "PORTREVISION=\t2\n" {
+		t.Errorf("got %s %q", res.Action, w.Bytes())
+	}
+}
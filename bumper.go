@@ -0,0 +1,908 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/dmgk/portbump/bump"
+)
+
+// PortBumper bumps PORTREVISION of ports in a ports tree.
+type PortBumper struct {
+	// Ports tree root
+	Root string
+	// Number of ports to process in parallel, the number of CPUs if less
+	// than 1
+	Jobs int
+	// PORTREVISION change to make
+	Options bump.Options
+	// Only report what would be changed
+	DryRun bool
+	// Produce diffs instead of modifying Makefiles
+	Diff bool
+	// Produce diffs in git diff format, with Diff
+	GitDiff bool
+	// Keep original Makefiles as Makefile.bak
+	Backup bool
+	// Restore Makefiles from Makefile.bak or Makefile.orig, removing the
+	// backup, instead of changing them
+	Restore bool
+	// Don't report anything but errors
+	Quiet bool
+	// Bump master ports instead of their slave ports
+	FollowMaster bool
+	// Only read current values into Result.OldRevision and NewRevision
+	Query bool
+	// Stop starting new ports after the first error or, if MaxFailures
+	// is set, after more than MaxFailures errors
+	FailFast    bool
+	MaxFailures int
+	// Report ports that were skipped or capped as errors
+	Strict bool
+	// Warn about ports marked deprecated or broken, refuse to bump them
+	// with Strict
+	WarnBroken bool
+	// Warn about ports with PORTREVISION, or PORTEPOCH, defined before
+	// the version lines or after CATEGORIES, refuse to bump them with Strict
+	StrictPlacement bool
+	// Number of times to retry a port after a transient filesystem error
+	Retries int
+	// Give up on ports taking longer than Timeout, 0 means no limit
+	Timeout time.Duration
+	// Maximum number of files open at once by all ports, 0 means no limit
+	MaxOpen int
+	// Name of port Makefiles, Makefile if empty
+	Makefile string
+	// Category of origins given without one, e.g. www for nginx, if set
+	DefaultCategory string
+	// Skip ports without any of Requires on a dependency line, like
+	// LIB_DEPENDS, of their or their master port Makefile
+	Requires []string
+	// Logger for diagnostic messages, may be nil
+	Log *Logger
+
+	// origins by real paths of Makefiles already processed in the run
+	mu      sync.Mutex
+	claimed map[string]string
+
+	// open files budget, see acquireFiles
+	files   chan struct{}
+	filesMu sync.Mutex
+
+	// port and category directory stats for the run, see statDir
+	dirsMu sync.Mutex
+	dirs   map[string]dirStat
+}
+
+// dirStat is a cached os.Stat result.
+type dirStat struct {
+	fi  fs.FileInfo
+	err error
+}
+
+// Job is an origin to process.
+type Job struct {
+	Origin string
+	// Amount to adjust PORTREVISION by instead of Options.Delta, if not 0
+	Delta int
+	// Change to make instead of PortBumper.Options, if set
+	Options *bump.Options
+}
+
+// Result is the outcome of bumping a single port.
+type Result struct {
+	// Position of the origin in the input
+	Index  int
+	Origin string
+	// Origin of the master port if the port is a slave port
+	Master string
+	// Origin of the port whose Makefile was processed, Master with
+	// PortBumper.FollowMaster and Origin otherwise
+	Port string
+	bump.Result
+	// Makefile diff, if requested
+	Diff []byte
+	Err  error
+
+	// Origin processed before with the same Makefile, the port was skipped
+	dupOf string
+	// Port has none of PortBumper.Requires dependencies, it was skipped
+	unmatched bool
+	// Backup file name the Makefile was restored from with
+	// PortBumper.Restore
+	restored string
+	// Why a port without the variable was skipped, see missingReason
+	missing string
+}
+
+// Process bumps origins of jobs received from the jobs channel, processing up to
+// pb.Jobs ports in parallel. No new ports are started after ctx is cancelled
+// or, with pb.FailFast, after more than pb.MaxFailures errors, but ports
+// already being processed are finished. The returned channel is closed after
+// all started ports are done.
+func (pb *PortBumper) Process(ctx context.Context, jobs <-chan Job) <-chan Result {
+	resch := make(chan Result)
+	if pb.Jobs < 1 {
+		pb.Jobs = runtime.NumCPU()
+	}
+	sem := make(chan int, pb.Jobs)
+	pb.mu.Lock()
+	pb.claimed = nil
+	pb.mu.Unlock()
+	pb.dirsMu.Lock()
+	pb.dirs = nil
+	pb.dirsMu.Unlock()
+	pb.filesMu.Lock()
+	pb.files = nil
+	if pb.MaxOpen > 0 {
+		pb.files = make(chan struct{}, pb.MaxOpen)
+	}
+	pb.filesMu.Unlock()
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		defer close(resch)
+		defer cancel()
+
+		var wg sync.WaitGroup
+		var i int
+		// number of failed ports, for pb.MaxFailures
+		var failures int32
+	loop:
+		for {
+			var j Job
+			var ok bool
+			select {
+			case j, ok = <-jobs:
+				if !ok {
+					break loop
+				}
+			case <-ctx.Done():
+				break loop
+			}
+			select {
+			case sem <- 1:
+			case <-ctx.Done():
+				break loop
+			}
+			if ctx.Err() != nil {
+				// cancelled while waiting for a free slot
+				break
+			}
+			wg.Add(1)
+
+			go func(i int, j Job) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				res := pb.bumpPortTimeout(j)
+				res.Index = i
+				if res.Err != nil && pb.FailFast && int(atomic.AddInt32(&failures, 1)) > pb.MaxFailures {
+					// before the slot is freed for the next port
+					cancel()
+				}
+				resch <- res
+			}(i, j)
+			i++
+		}
+		wg.Wait()
+	}()
+
+	return resch
+}
+
+// normalizeOrigin converts origin given as a relative path or as an absolute
+// path under pb.Root to the category/port form. Origins without a category
+// are put in pb.DefaultCategory, if it's set.
+func (pb *PortBumper) normalizeOrigin(origin string) string {
+	origin = filepath.Clean(origin)
+	root := filepath.Clean(pb.Root) + string(filepath.Separator)
+	if strings.HasPrefix(origin, root) {
+		origin = origin[len(root):]
+	}
+	if pb.DefaultCategory != "" && !strings.ContainsRune(origin, filepath.Separator) && origin != "." && origin != ".." {
+		origin = filepath.Join(pb.DefaultCategory, origin)
+	}
+	return origin
+}
+
+// workingCategory returns the category of the ports tree the working
+// directory is in, in the category directory itself or deeper, or an empty
+// string if it's not under pb.Root.
+func (pb *PortBumper) workingCategory() string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	// the ports tree is often reached through a symlink
+	root, err := filepath.EvalSymlinks(pb.Root)
+	if err == nil {
+		wd, err = filepath.EvalSymlinks(wd)
+	}
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(root, wd)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	category, _, _ := strings.Cut(rel, string(filepath.Separator))
+	return category
+}
+
+// makefile returns the name of port Makefiles.
+func (pb *PortBumper) makefile() string {
+	if pb.Makefile == "" {
+		return "Makefile"
+	}
+	return pb.Makefile
+}
+
+// isGlob reports whether origin contains shell wildcards.
+func isGlob(origin string) bool {
+	return strings.ContainsAny(origin, "*?[")
+}
+
+// expandGlob returns origins of ports with a Makefile whose directories
+// under pb.Root match pattern.
+func (pb *PortBumper) expandGlob(pattern string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(pb.Root, pattern, pb.makefile()))
+	if err != nil {
+		return nil, err
+	}
+	origins := make([]string, 0, len(paths))
+	for _, p := range paths {
+		origins = append(origins, pb.normalizeOrigin(filepath.Dir(p)))
+	}
+	return origins, nil
+}
+
+// checkOrigin returns an error if normalized origin doesn't look like category/port.
+func checkOrigin(origin string) error {
+	parts := strings.Split(origin, string(filepath.Separator))
+	if len(parts) != 2 || parts[0] == "" || parts[0] == ".." {
+		return errors.New("invalid origin, expected category/port")
+	}
+	return nil
+}
+
+// subdirRe matches SUBDIR assignments in the ports tree root Makefile.
+var subdirRe = regexp.MustCompile(`(?m)^[ \t]*SUBDIR[ \t]*\+?=`)
+
+// checkRoot returns an error if pb.Root doesn't look like a ports tree, with
+// neither a Mk directory nor a Makefile listing categories in SUBDIR.
+func (pb *PortBumper) checkRoot() error {
+	if fi, err := os.Stat(filepath.Join(pb.Root, "Mk")); err == nil && fi.IsDir() {
+		return nil
+	}
+	buf, err := os.ReadFile(filepath.Join(pb.Root, "Makefile"))
+	if err == nil && subdirRe.Match(buf) {
+		return nil
+	}
+	if _, err := os.Stat(pb.Root); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("ports tree %s not found", pb.Root)
+	}
+	return fmt.Errorf("%s is not a ports tree: no Mk directory or Makefile with SUBDIR", pb.Root)
+}
+
+// checkPortDir returns a descriptive error if origin directory doesn't exist.
+func (pb *PortBumper) checkPortDir(origin string) error {
+	dir := filepath.Join(pb.Root, origin)
+	fi, err := pb.statDir(dir)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if _, err := pb.statDir(filepath.Dir(dir)); errors.Is(err, fs.ErrNotExist) {
+			return errors.New("not a port: category directory not found")
+		}
+		return errors.New("not a port: port directory not found")
+	}
+	if !fi.IsDir() {
+		return errors.New("not a port: not a directory")
+	}
+	return nil
+}
+
+// statDir is os.Stat of dir, cached until the next Process call. Ports are
+// looked up more than once and sweeps of many ports in few categories look up
+// the same categories over and over. Only errors other than dir not existing
+// are not cached, they may be transient.
+func (pb *PortBumper) statDir(dir string) (fs.FileInfo, error) {
+	pb.dirsMu.Lock()
+	st, ok := pb.dirs[dir]
+	pb.dirsMu.Unlock()
+	if ok {
+		return st.fi, st.err
+	}
+
+	fi, err := os.Stat(dir)
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		pb.dirsMu.Lock()
+		if pb.dirs == nil {
+			pb.dirs = map[string]dirStat{}
+		}
+		pb.dirs[dir] = dirStat{fi, err}
+		pb.dirsMu.Unlock()
+	}
+	return fi, err
+}
+
+// bumpPort processes the job origin or, with pb.FollowMaster, its master port.
+func (pb *PortBumper) bumpPort(j Job) Result {
+	origin := j.Origin
+	pb.Log.Debugf("%s: processing", origin)
+	res := Result{Origin: origin, Port: origin}
+	if err := checkOrigin(origin); err != nil {
+		res.Err = err
+		return res
+	}
+	if err := pb.checkPortDir(origin); err != nil {
+		res.Err = err
+		return res
+	}
+	// taken before the Makefile is first read for MASTERDIR and
+	// dependencies, so that all of the port reads count
+	defer pb.releaseFiles(pb.acquireFiles(pb.portFiles()))
+
+	master, err := pb.masterPort(origin)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.Master = master
+	if pb.FollowMaster && master != "" {
+		res.Port = master
+	}
+	if len(pb.Requires) > 0 {
+		ok, err := pb.requires(origin, master)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		if !ok {
+			res.unmatched = true
+			return res
+		}
+	}
+	// slaves of the same master with FollowMaster, the master itself or
+	// ports symlinked to each other may be given too, make sure each
+	// Makefile is bumped only once
+	if !pb.Query {
+		if res.dupOf = pb.claim(origin, res.Port); res.dupOf != "" {
+			return res
+		}
+	}
+
+	if pb.Restore {
+		res.restored, res.Err = pb.restoreMakefile(res.Port)
+		return res
+	}
+
+	opts := pb.Options
+	if j.Options != nil {
+		opts = *j.Options
+	}
+	if j.Delta != 0 {
+		opts.Delta = j.Delta
+	}
+	res.Result, res.Diff, res.Err = pb.processPort(res.Port, opts)
+	if res.Err == nil && res.Action == bump.Skipped && res.OldRevision == 0 {
+		res.missing = missingReason(opts)
+	}
+	if pb.Strict && res.Err == nil && !pb.Query {
+		name := pb.Options.Target()
+		switch {
+		case res.Action == bump.Capped:
+			res.Err = fmt.Errorf("%s %d would exceed %d", name, res.NewRevision, pb.Options.Max)
+		case res.Action == bump.Skipped && res.OldRevision > 0:
+			res.Err = fmt.Errorf("%s %d unchanged", name, res.OldRevision)
+		case res.Action == bump.Skipped:
+			res.Err = errors.New(res.missing)
+		}
+	}
+	return res
+}
+
+// missingReason describes why opts left a Makefile without the variable
+// alone. Only bumping adds a missing definition, which takes a version line
+// to add it after.
+func missingReason(opts bump.Options) string {
+	name := opts.Target()
+	switch {
+	case opts.Set && opts.Value == 0, !opts.Set && opts.ResetRevision && !opts.Epoch:
+		return fmt.Sprintf("no %s to remove", name)
+	case !opts.Set && opts.Delta < 0:
+		return fmt.Sprintf("no %s to decrement", name)
+	}
+	return fmt.Sprintf("no %s or version to bump", name)
+}
+
+// backup file suffixes restoreMakefile looks for, in order of preference
+var backupSuffixes = []string{".bak", ".orig"}
+
+// restoreMakefile renames a backup of origin Makefile over it and returns
+// the backup file name. With pb.DryRun it only looks for the backup.
+func (pb *PortBumper) restoreMakefile(origin string) (string, error) {
+	if err := pb.checkPortDir(origin); err != nil {
+		return "", err
+	}
+
+	makefilePath := filepath.Join(pb.Root, origin, pb.makefile())
+	// backups of symlinked Makefiles are kept next to the real ones
+	if path, err := filepath.EvalSymlinks(makefilePath); err == nil {
+		makefilePath = path
+	}
+	for _, suffix := range backupSuffixes {
+		path := makefilePath + suffix
+		fi, err := os.Lstat(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return "", err
+		}
+		if !fi.Mode().IsRegular() {
+			return "", fmt.Errorf("backup %s is not a regular file", path)
+		}
+		if !pb.DryRun {
+			if err := os.Rename(path, makefilePath); err != nil {
+				return "", fmt.Errorf("error restoring %s: %w", makefilePath, err)
+			}
+			pb.Log.Debugf("%s: restored %s from %s", origin, makefilePath, path)
+		}
+		return filepath.Base(path), nil
+	}
+	return "", fmt.Errorf("no %s%s or %s%s backup", pb.makefile(), backupSuffixes[0], pb.makefile(), backupSuffixes[1])
+}
+
+// bumpPortTimeout is bumpPort giving up after pb.Timeout, if set. I/O of
+// a port that timed out is left running in the background, so its Makefile
+// may still be changed if it eventually completes.
+func (pb *PortBumper) bumpPortTimeout(j Job) Result {
+	if pb.Timeout <= 0 {
+		return pb.bumpPort(j)
+	}
+
+	ch := make(chan Result, 1)
+	go func() {
+		ch <- pb.bumpPort(j)
+	}()
+	t := time.NewTimer(pb.Timeout)
+	defer t.Stop()
+	select {
+	case res := <-ch:
+		return res
+	case <-t.C:
+		return Result{Origin: j.Origin, Port: j.Origin, Err: fmt.Errorf("timed out after %s", pb.Timeout)}
+	}
+}
+
+// claim marks the Makefile of port as processed for origin. If it already
+// was, claim returns the origin it was processed for.
+func (pb *PortBumper) claim(origin, port string) string {
+	path, err := filepath.EvalSymlinks(filepath.Join(pb.Root, port, pb.makefile()))
+	if err == nil {
+		path, err = filepath.Abs(path)
+	}
+	if err != nil {
+		// let processPort report it
+		return ""
+	}
+
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	if o, ok := pb.claimed[path]; ok {
+		return o
+	}
+	if pb.claimed == nil {
+		pb.claimed = map[string]string{}
+	}
+	pb.claimed[path] = origin
+	return ""
+}
+
+var masterdirRe = regexp.MustCompile(`(?m)^[ \t]*MASTERDIR[ \t]*\??=[ \t]*(\S+)`)
+
+// masterPort returns the master port origin of slave port origin, or an empty
+// string if origin is not a slave port.
+func (pb *PortBumper) masterPort(origin string) (string, error) {
+	dir := filepath.Join(pb.Root, origin)
+	buf, err := os.ReadFile(filepath.Join(dir, pb.makefile()))
+	if err != nil {
+		// processPort reports Makefile errors
+		return "", nil
+	}
+	m := masterdirRe.FindSubmatch(buf)
+	if m == nil {
+		return "", nil
+	}
+
+	masterDir := strings.NewReplacer(
+		"${.CURDIR}", dir,
+		"${PORTSDIR}", pb.Root,
+	).Replace(string(m[1]))
+	if strings.Contains(masterDir, "$") {
+		return "", fmt.Errorf("unable to resolve MASTERDIR %s", m[1])
+	}
+	if !filepath.IsAbs(masterDir) {
+		masterDir = filepath.Join(dir, masterDir)
+	}
+
+	master := pb.normalizeOrigin(masterDir)
+	if checkOrigin(master) != nil {
+		return "", fmt.Errorf("MASTERDIR %s is not a port in the ports tree", m[1])
+	}
+	if master == origin {
+		return "", nil
+	}
+	pb.Log.Debugf("%s: MASTERDIR is %s", origin, master)
+	return master, nil
+}
+
+// processPort applies opts to the Makefile of origin, retrying up to
+// pb.Retries times after transient errors.
+func (pb *PortBumper) processPort(origin string, opts bump.Options) (bump.Result, []byte, error) {
+	if err := pb.checkPortDir(origin); err != nil {
+		return bump.Result{}, nil, err
+	}
+
+	makefilePath := filepath.Join(pb.Root, origin, pb.makefile())
+
+	delay := retryDelay
+	for try := 0; ; try++ {
+		res, diff, err := pb.bumpMakefile(origin, makefilePath, opts)
+		if err == nil || try == pb.Retries || !isTransient(err) {
+			return res, diff, err
+		}
+		pb.Log.Debugf("%s: %s, retrying in %s", origin, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// portFiles returns the number of files open at once while processing a
+// port: the Makefile, its replacement and the backup.
+func (pb *PortBumper) portFiles() int {
+	n := 1
+	if !pb.Query && !pb.DryRun && !pb.Diff && !pb.Restore {
+		n++
+		if pb.Backup {
+			n++
+		}
+	}
+	return n
+}
+
+// acquireFiles waits until n more files can be opened within pb.MaxOpen and
+// returns the budget they were taken from and the number of files to release
+// to it when they are closed. Ports still running after their Process call
+// returned thus can't take from or release to the budget of the next one.
+func (pb *PortBumper) acquireFiles(n int) (chan struct{}, int) {
+	// taking all files at once, workers can't starve each other holding
+	// parts of what they need
+	pb.filesMu.Lock()
+	defer pb.filesMu.Unlock()
+	files := pb.files
+	if files == nil {
+		return nil, 0
+	}
+	if n > cap(files) {
+		n = cap(files)
+	}
+	for i := 0; i < n; i++ {
+		files <- struct{}{}
+	}
+	return files, n
+}
+
+// releaseFiles returns n files acquired with acquireFiles to files.
+func (pb *PortBumper) releaseFiles(files chan struct{}, n int) {
+	for i := 0; i < n; i++ {
+		<-files
+	}
+}
+
+// initial delay before retrying after a transient error, doubled on each retry
+const retryDelay = 100 * time.Millisecond
+
+// isTransient reports whether err is worth retrying.
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR)
+}
+
+// bumpMakefile does a single attempt at processing makefilePath of origin.
+// The Makefile is left unchanged if it returns an error.
+func (pb *PortBumper) bumpMakefile(origin, makefilePath string, opts bump.Options) (bump.Result, []byte, error) {
+	if pb.Query {
+		buf, err := os.ReadFile(makefilePath)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return bump.Result{}, nil, fmt.Errorf("not a port: %s not found", pb.makefile())
+			}
+			return bump.Result{}, nil, err
+		}
+		rev, err := bump.Current(buf, opts)
+		return bump.Result{OldRevision: rev, NewRevision: rev}, nil, err
+	}
+
+	// Makefiles symlinked to other ports are changed where they point to,
+	// keeping the link
+	makefilePath, err := filepath.EvalSymlinks(makefilePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return bump.Result{}, nil, fmt.Errorf("not a port: %s not found", pb.makefile())
+		}
+		return bump.Result{}, nil, err
+	}
+	f, err := os.Open(makefilePath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return bump.Result{}, nil, fmt.Errorf("not a port: %s not found", pb.makefile())
+		}
+		return bump.Result{}, nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return bump.Result{}, nil, err
+	}
+
+	// original content is needed for diffs and backups
+	fbuf := bufGet()
+	defer bufPut(fbuf)
+	fbuf.Grow(int(fi.Size()) + bytes.MinRead)
+
+	tmp := &tempFile{path: makefilePath, fi: fi}
+	var w io.Writer = tmp
+	if pb.Diff || pb.DryRun {
+		w = io.Discard
+	}
+
+	res, err := bump.Stream(io.TeeReader(f, fbuf), w, opts)
+	if err != nil {
+		tmp.abort()
+		return bump.Result{}, nil, err
+	}
+	// don't hold on to the Makefile content while the result is queued
+	buf := res.Buf
+	res.Buf = nil
+
+	if !opts.Flavors && res.Action.Changed() && flavorsRe.Match(fbuf.Bytes()) {
+		pb.Log.Warnf("%s: flavored port, per-flavor %s definitions are only changed with --flavors", origin, opts.Target())
+	}
+	if pb.WarnBroken && res.Action.Changed() {
+		if m := brokenMarkers(fbuf.Bytes()); len(m) > 0 {
+			if pb.Strict {
+				tmp.abort()
+				return bump.Result{}, nil, fmt.Errorf("%s set", strings.Join(m, ", "))
+			}
+			pb.Log.Warnf("%s: %s set, bumping it anyway", origin, strings.Join(m, ", "))
+		}
+	}
+	if pb.StrictPlacement && res.Action.Changed() {
+		if err := bump.CheckPlacement(fbuf.Bytes(), opts); err != nil {
+			if pb.Strict {
+				tmp.abort()
+				return bump.Result{}, nil, err
+			}
+			pb.Log.Warnf("%s: %s, bumping it anyway", origin, err)
+		}
+	}
+
+	if pb.Diff {
+		if pb.GitDiff {
+			return res, gitPatch(origin+"/"+pb.makefile(), fbuf.Bytes(), buf), nil
+		}
+		return res, unifiedDiff(filepath.Join(origin, pb.makefile()), fbuf.Bytes(), buf), nil
+	}
+	if pb.DryRun {
+		return res, nil, nil
+	}
+
+	backup := pb.Backup && res.Action.Changed()
+	if backup {
+		err = writeBackup(makefilePath+".bak", fbuf.Bytes(), fi)
+		if err != nil {
+			tmp.abort()
+			return bump.Result{}, nil, err
+		}
+	}
+
+	if err := tmp.commit(); err != nil {
+		if backup {
+			// the Makefile wasn't changed, don't block retrying it
+			os.Remove(makefilePath + ".bak")
+		}
+		return bump.Result{}, nil, err
+	}
+	if res.Action.Changed() {
+		pb.Log.Debugf("%s: replaced %s", origin, makefilePath)
+	}
+	return res, nil, nil
+}
+
+// flavorsRe matches FLAVORS assignments of flavored ports.
+var flavorsRe = regexp.MustCompile(`(?m)^[ \t]*FLAVORS[ \t]*[?+:!]?=`)
+
+// dependsRe matches assignments of dependency variables, including option
+// helpers like FOO_LIB_DEPENDS.
+var dependsRe = regexp.MustCompile(`^[ \t]*[A-Za-z0-9_]*DEPENDS[ \t]*[?+:!]?=`)
+
+// requires reports whether Makefiles of origin or its master port, if it's
+// not empty, have any of pb.Requires on a dependency line.
+func (pb *PortBumper) requires(origin, master string) (bool, error) {
+	for _, o := range []string{origin, master} {
+		if o == "" {
+			continue
+		}
+		buf, err := os.ReadFile(filepath.Join(pb.Root, o, pb.makefile()))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return false, fmt.Errorf("not a port: %s not found", pb.makefile())
+			}
+			return false, err
+		}
+		if hasDependency(buf, pb.Requires) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// hasDependency reports whether any dependency line in Makefile buf,
+// joined with lines continuing it, contains any of deps.
+func hasDependency(buf []byte, deps []string) bool {
+	var line []byte
+	for len(buf) > 0 {
+		var l []byte
+		l, buf, _ = bytes.Cut(buf, []byte("\n"))
+		line = append(line, l...)
+		if bytes.HasSuffix(line, []byte("\\")) {
+			line = line[:len(line)-1]
+			continue
+		}
+		if dependsRe.Match(line) {
+			for _, d := range deps {
+				if bytes.Contains(line, []byte(d)) {
+					return true
+				}
+			}
+		}
+		line = line[:0]
+	}
+	return false
+}
+
+// brokenRe matches assignments of variables marking ports deprecated or
+// broken, including per-architecture and per-version BROKEN_* variants.
+var brokenRe = regexp.MustCompile(`(?m)^[ \t]*((?:DEPRECATED|EXPIRATION_DATE|BROKEN)(?:_[A-Za-z0-9_]+)?)[ \t]*[?+:!]?=`)
+
+// brokenMarkers returns names of variables marking the port in Makefile buf
+// deprecated or broken, in the order of appearance.
+func brokenMarkers(buf []byte) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, m := range brokenRe.FindAllSubmatch(buf, -1) {
+		if name := string(m[1]); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// writeBackup saves buf to path, refusing to overwrite an existing backup.
+// Backup mode and, where possible, ownership are copied from fi.
+func writeBackup(path string, buf []byte, fi os.FileInfo) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("backup %s already exists", path)
+		}
+		return err
+	}
+
+	_, err = f.Write(buf)
+	if err == nil {
+		err = copyMode(f, fi)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// tempFile is an io.Writer that creates a temporary file in the same
+// directory as path on the first write. Committing it atomically replaces
+// path, copying mode and, where possible, ownership from fi.
+type tempFile struct {
+	path string
+	fi   os.FileInfo
+	f    *os.File
+}
+
+func (t *tempFile) Write(p []byte) (int, error) {
+	if t.f == nil {
+		f, err := os.CreateTemp(filepath.Dir(t.path), "."+filepath.Base(t.path)+".*")
+		if err != nil {
+			return 0, err
+		}
+		t.f = f
+	}
+	return t.f.Write(p)
+}
+
+// commit renames the temporary file, if it was created, over path.
+func (t *tempFile) commit() error {
+	if t.f == nil {
+		return nil
+	}
+
+	err := copyMode(t.f, t.fi)
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(t.f.Name(), t.path)
+		if err != nil {
+			err = fmt.Errorf("error replacing %s: %w", t.path, err)
+		}
+	}
+	if err != nil {
+		os.Remove(t.f.Name())
+	}
+	return err
+}
+
+// abort removes the temporary file, if it was created.
+func (t *tempFile) abort() {
+	if t.f != nil {
+		t.f.Close()
+		os.Remove(t.f.Name())
+	}
+}
+
+// copyMode sets f permissions, including setuid, setgid and sticky bits, and,
+// where possible, ownership to those of fi. Mode is set explicitly to not be
+// affected by umask.
+func copyMode(f *os.File, fi os.FileInfo) error {
+	// chown may clear setuid and setgid bits, so it goes first
+	if err := chown(f, fi); err != nil {
+		return err
+	}
+	return f.Chmod(fi.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky))
+}
+
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func bufGet() *bytes.Buffer {
+	return bufPool.Get().(*bytes.Buffer)
+}
+
+func bufPut(b *bytes.Buffer) {
+	b.Reset()
+	bufPool.Put(b)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dmgk/portbump/bump"
+)
+
+func TestReplaceShorter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Makefile")
+	if err := os.WriteFile(path, []byte("PORTNAME=\tx\nPORTVERSION=\t1.0\nPORTREVISION=\t1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "PORTNAME=\tx\n"
+	tmp := &tempFile{path: path, fi: fi}
+	if _, err := tmp.Write([]byte(want)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmp.commit(); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != want {
+		t.Errorf("got %q, want %q", buf, want)
+	}
+}
+
+// process runs pb on origins and returns the results, in order with
+// pb.Jobs set to 1.
+func process(pb *PortBumper, origins ...string) []Result {
+	jobs := make(chan Job, len(origins))
+	for _, o := range origins {
+		jobs <- Job{Origin: o}
+	}
+	close(jobs)
+
+	var res []Result
+	for r := range pb.Process(context.Background(), jobs) {
+		res = append(res, r)
+	}
+	return res
+}
+
+// writePorts creates files, by paths relative to the ports tree root, in
+// a temporary ports tree and returns its root.
+func writePorts(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for path, content := range files {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func readFile(t *testing.T, path string) string {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestProcessSlave(t *testing.T) {
+	root := writePorts(t, map[string]string{
+		"www/a/Makefile": "PORTNAME=\ta\nPORTVERSION=\t1.0\n",
+		"www/s/Makefile": "PORTREVISION=\t1\nMASTERDIR=\t${.CURDIR}/../a\n\n.include \"${MASTERDIR}/Makefile\"\n",
+	})
+	master := filepath.Join(root, "www/a/Makefile")
+	slave := filepath.Join(root, "www/s/Makefile")
+
+	// the slave is bumped, Master is set for the caller to warn about it
+	pb := &PortBumper{Root: root, Jobs: 1, Options: bump.Options{Delta: 1}}
+	res := process(pb, "www/s")
+	if len(res) != 1 || res[0].Err != nil {
+		t.Fatalf("got %+v, want a result without an error", res)
+	}
+	if res[0].Master != "www/a" || res[0].Port != "www/s" || res[0].Action != bump.Bumped {
+		t.Errorf("got master %q, port %q, %s, want www/a, www/s, bumped", res[0].Master, res[0].Port, res[0].Action)
+	}
+	if got := readFile(t, slave); !strings.HasPrefix(got, "PORTREVISION=\t2\n") {
+		t.Errorf("slave Makefile not bumped:\n%s", got)
+	}
+
+	// the master is bumped once, for the slave given first
+	pb = &PortBumper{Root: root, Jobs: 1, FollowMaster: true, Options: bump.Options{Delta: 1}}
+	res = process(pb, "www/s", "www/a")
+	if len(res) != 2 {
+		t.Fatalf("got %d results, want 2", len(res))
+	}
+	if res[0].Err != nil || res[0].Port != "www/a" || res[0].Action != bump.Added {
+		t.Errorf("www/s: got %v, port %q, %s, want www/a, added", res[0].Err, res[0].Port, res[0].Action)
+	}
+	if res[1].Err != nil || res[1].dupOf != "www/s" {
+		t.Errorf("www/a: got %v, duplicate of %q, want duplicate of www/s", res[1].Err, res[1].dupOf)
+	}
+	if got, want := readFile(t, master), "PORTNAME=\ta\nPORTVERSION=\t1.0\nPORTREVISION=\t1\n"; got != want {
+		t.Errorf("got master Makefile\n%q\nwant\n%q", got, want)
+	}
+	if got := readFile(t, slave); !strings.HasPrefix(got, "PORTREVISION=\t2\n") {
+		t.Errorf("slave Makefile changed with FollowMaster:\n%s", got)
+	}
+}
+
+func TestProcessKeepsMode(t *testing.T) {
+	root := writePorts(t, map[string]string{"www/a/Makefile": "PORTVERSION=\t1.0\n"})
+	makefile := filepath.Join(root, "www/a/Makefile")
+	// WriteFile is subject to umask
+	if err := os.Chmod(makefile, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	pb := &PortBumper{Root: root, Jobs: 1, Backup: true, Options: bump.Options{Delta: 1}}
+	res := process(pb, "www/a")
+	if len(res) != 1 || res[0].Err != nil || res[0].Action != bump.Added {
+		t.Fatalf("got %+v, want added", res)
+	}
+	for _, path := range []string{makefile, makefile + ".bak"} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0640 {
+			t.Errorf("%s: got mode %o, want 640", path, fi.Mode().Perm())
+		}
+	}
+}
+
+func TestProcessRemovesLines(t *testing.T) {
+	const src = "PORTNAME=\ta\nPORTVERSION=\t1.0\nPORTREVISION=\t1\nCATEGORIES=\twww\n\n.include <bsd.port.mk>\n"
+	const want = "PORTNAME=\ta\nPORTVERSION=\t1.0\nCATEGORIES=\twww\n\n.include <bsd.port.mk>\n"
+
+	for _, opts := range []bump.Options{{Delta: -1}, {ResetRevision: true}} {
+		root := writePorts(t, map[string]string{"www/a/Makefile": src})
+		pb := &PortBumper{Root: root, Jobs: 1, Options: opts}
+		res := process(pb, "www/a")
+		if len(res) != 1 || res[0].Err != nil || !res[0].Action.Changed() {
+			t.Fatalf("%+v: got %+v, want PORTREVISION removed", opts, res)
+		}
+		// no bytes of the longer original are left at the end
+		if got := readFile(t, filepath.Join(root, "www/a/Makefile")); got != want {
+			t.Errorf("%+v: got\n%q\nwant\n%q", opts, got, want)
+		}
+	}
+}
+
+func TestProcessSameMakefile(t *testing.T) {
+	root := writePorts(t, map[string]string{"www/b/Makefile": "PORTNAME=\tb\nPORTVERSION=\t1.0\n"})
+	makefile := filepath.Join(root, "www/b/Makefile")
+	link := filepath.Join(root, "www/link/Makefile")
+	if err := os.Mkdir(filepath.Dir(link), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../b/Makefile", link); err != nil {
+		t.Fatal(err)
+	}
+
+	pb := &PortBumper{Root: root, Jobs: 1, Backup: true, Options: bump.Options{Delta: 1}}
+	res := process(pb, "www/link", "www/b")
+	if len(res) != 2 {
+		t.Fatalf("got %d results, want 2", len(res))
+	}
+	if res[0].Err != nil || res[0].Action != bump.Added {
+		t.Errorf("www/link: got %s, %v, want added", res[0].Action, res[0].Err)
+	}
+	if res[1].Err != nil || res[1].dupOf != "www/link" {
+		t.Errorf("www/b: got %v, duplicate of %q, want duplicate of www/link", res[1].Err, res[1].dupOf)
+	}
+
+	fi, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%s was replaced with a regular file", link)
+	}
+	if got, want := readFile(t, makefile), "PORTNAME=\tb\nPORTVERSION=\t1.0\nPORTREVISION=\t1\n"; got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+	if _, err := os.Stat(makefile + ".bak"); err != nil {
+		t.Errorf("no backup next to the link target: %s", err)
+	}
+
+	// Makefiles processed by an earlier run are processed again
+	pb.Backup = false
+	res = process(pb, "www/b")
+	if len(res) != 1 || res[0].Err != nil || res[0].Action != bump.Bumped {
+		t.Errorf("second run: got %+v, want bumped", res)
+	}
+}
+
+func TestBrokenMarkers(t *testing.T) {
+	buf := []byte("BROKEN_armv7=\tfails\nDEPRECATED=\told\n#BROKEN=\tcomment\nEXPIRATION_DATE=\t2030-01-01\nBROKEN_armv7=\tagain\n")
+	if got, want := strings.Join(brokenMarkers(buf), " "), "BROKEN_armv7 DEPRECATED EXPIRATION_DATE"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if m := brokenMarkers([]byte("PORTNAME=\tx\nNOT_BROKEN=\t1\n")); m != nil {
+		t.Errorf("got %q, want none", m)
+	}
+}
+
+func TestProcessWarnBroken(t *testing.T) {
+	ports := map[string]string{
+		"www/a/Makefile": "PORTVERSION=\t1.0\nDEPRECATED=\told\nEXPIRATION_DATE=\t2030-01-01\n",
+		"www/b/Makefile": "PORTVERSION=\t1.0\nBROKEN_armv7=\tfails\n",
+		"www/c/Makefile": "PORTVERSION=\t1.0\n",
+	}
+	markers := map[string]string{
+		"www/a": "DEPRECATED, EXPIRATION_DATE set",
+		"www/b": "BROKEN_armv7 set",
+	}
+
+	for _, strict := range []bool{false, true} {
+		root := writePorts(t, ports)
+		var log bytes.Buffer
+		pb := &PortBumper{
+			Root:       root,
+			Jobs:       1,
+			Strict:     strict,
+			WarnBroken: true,
+			Options:    bump.Options{Delta: 1},
+			Log:        &Logger{W: &log, Level: LevelWarn},
+		}
+		for _, res := range process(pb, "www/a", "www/b", "www/c") {
+			path := filepath.Join("www", filepath.Base(res.Origin), "Makefile")
+			switch m := markers[res.Origin]; {
+			case m == "":
+				if res.Err != nil || res.Action != bump.Added {
+					t.Errorf("strict %t: %s: got %s, %v, want added", strict, res.Origin, res.Action, res.Err)
+				}
+			case strict:
+				// refused, Makefile left alone
+				if res.Err == nil || res.Err.Error() != m {
+					t.Errorf("strict %t: %s: got error %v, want %q", strict, res.Origin, res.Err, m)
+				}
+				if got := readFile(t, filepath.Join(root, path)); got != ports[path] {
+					t.Errorf("strict %t: %s: Makefile changed:\n%s", strict, res.Origin, got)
+				}
+			default:
+				// warned about and bumped
+				if res.Err != nil || res.Action != bump.Added {
+					t.Errorf("strict %t: %s: got %s, %v, want added", strict, res.Origin, res.Action, res.Err)
+				}
+				if want := res.Origin + ": " + m + ", bumping it anyway"; !strings.Contains(log.String(), want) {
+					t.Errorf("strict %t: %s: no %q warning in\n%s", strict, res.Origin, want, log.String())
+				}
+			}
+		}
+		if strict && log.Len() > 0 {
+			t.Errorf("strict %t: got warnings\n%s", strict, log.String())
+		}
+	}
+}
+
+func TestProcessUnchangedMakefile(t *testing.T) {
+	root := writePorts(t, map[string]string{"www/a/Makefile": "PORTNAME=\ta\n"})
+	makefile := filepath.Join(root, "www/a/Makefile")
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(makefile, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(makefile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pb := &PortBumper{Root: root, Jobs: 1, Options: bump.Options{Delta: 1}}
+	res := process(pb, "www/a")
+	if len(res) != 1 || res[0].Err != nil || res[0].Action != bump.Skipped {
+		t.Fatalf("got %+v, want skipped", res)
+	}
+
+	nfi, err := os.Stat(makefile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(fi, nfi) {
+		t.Errorf("%s was replaced", makefile)
+	}
+	if !nfi.ModTime().Equal(fi.ModTime()) {
+		t.Errorf("got mtime %s, want %s", nfi.ModTime(), fi.ModTime())
+	}
+	entries, err := os.ReadDir(filepath.Dir(makefile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".Makefile.") {
+			t.Errorf("temporary file %s left behind", e.Name())
+		}
+	}
+}
+
+func TestProcessDefaultJobs(t *testing.T) {
+	root := writePorts(t, map[string]string{"www/a/Makefile": "PORTVERSION=\t1.0\n"})
+
+	pb := &PortBumper{Root: root, Options: bump.Options{Delta: 1}}
+	res := process(pb, "www/a")
+	if len(res) != 1 || res[0].Err != nil || res[0].Action != bump.Added {
+		t.Fatalf("got %+v, want added", res)
+	}
+	if pb.Jobs < 1 {
+		t.Errorf("got %d jobs, want at least 1", pb.Jobs)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// short options taking file and directory arguments, completed as paths
+const (
+	fileArgOpts = "fXSoI"
+	dirArgOpts  = "R"
+)
+
+// fileArgLongOpts are long options taking file arguments.
+var fileArgLongOpts = map[string]bool{
+	"manifest":  true,
+	"pkg-query": true,
+}
+
+// hiddenOptions are long options left out of usage and completions.
+var hiddenOptions = map[string]bool{
+	"completion":         true,
+	"completion-origins": true,
+}
+
+// completionData is passed to completion script templates.
+type completionData struct {
+	Progname string
+	// function name derived from Progname
+	Func string
+	// default ports tree root, used if PORTSDIR is not set and -R isn't given
+	Root string
+	// all options, with leading dashes
+	Opts []string
+	// options with file and directory arguments
+	FileOpts, DirOpts []string
+	// options without arguments and ones with arguments that aren't completed
+	Flags, ArgOpts []string
+	// complete port origins by listing ports tree directories
+	Origins bool
+}
+
+var completionTmpls = map[string]*template.Template{
+	"bash": template.Must(template.New("bash").Funcs(completionFuncs).Parse(bashCompletion)),
+	"zsh":  template.Must(template.New("zsh").Funcs(completionFuncs).Parse(zshCompletion)),
+	"fish": template.Must(template.New("fish").Funcs(completionFuncs).Parse(fishCompletion)),
+}
+
+var completionFuncs = template.FuncMap{
+	"join": strings.Join,
+	// single quote s for the shell
+	"quote": func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	},
+	// fish wants option names without dashes, after -s or -l
+	"fishopt": func(s string) string {
+		if strings.HasPrefix(s, "--") {
+			return "-l " + s[2:]
+		}
+		return "-s " + s[1:]
+	},
+}
+
+// writeCompletion writes a completion script for shell to w. Options are
+// taken from optstring and longopts so that scripts never go out of date.
+// With origins the script also completes port origins under the ports tree
+// root, one directory level at a time.
+func writeCompletion(w io.Writer, shell, optstring string, longopts map[string]bool, root string, origins bool) error {
+	t, ok := completionTmpls[shell]
+	if !ok {
+		return fmt.Errorf("unsupported shell: %s", shell)
+	}
+
+	data := completionData{
+		Progname: progname,
+		Func: "_" + strings.Map(func(r rune) rune {
+			if r == '-' || r == '.' {
+				return '_'
+			}
+			return r
+		}, progname),
+		Root:    root,
+		Origins: origins,
+	}
+	for i := 0; i < len(optstring); i++ {
+		c := optstring[i : i+1]
+		opt := "-" + c
+		data.Opts = append(data.Opts, opt)
+		if i+1 == len(optstring) || optstring[i+1] != ':' {
+			data.Flags = append(data.Flags, opt)
+			continue
+		}
+		i++
+		switch {
+		case strings.Contains(fileArgOpts, c):
+			data.FileOpts = append(data.FileOpts, opt)
+		case strings.Contains(dirArgOpts, c):
+			data.DirOpts = append(data.DirOpts, opt)
+		case c == "C", c == "x" && origins:
+			// completed by the script templates
+		default:
+			data.ArgOpts = append(data.ArgOpts, opt)
+		}
+	}
+	var names []string
+	for name := range longopts {
+		if !hiddenOptions[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data.Opts = append(data.Opts, "--"+name)
+		switch {
+		case fileArgLongOpts[name]:
+			data.FileOpts = append(data.FileOpts, "--"+name)
+		case longopts[name]:
+			data.ArgOpts = append(data.ArgOpts, "--"+name)
+		default:
+			data.Flags = append(data.Flags, "--"+name)
+		}
+	}
+
+	return t.Execute(w, data)
+}
+
+const bashCompletion = `# bash completion for {{.Progname}}, generated by {{.Progname}} --completion bash
+{{.Func}}() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+
+	case $prev in
+	-C)
+		COMPREPLY=($(compgen -W "auto always never" -- "$cur"))
+		return
+		;;
+	{{join .DirOpts "|"}})
+		COMPREPLY=($(compgen -d -- "$cur"))
+		return
+		;;
+	{{join .FileOpts "|"}})
+		COMPREPLY=($(compgen -f -- "$cur"))
+		return
+		;;
+	{{join .ArgOpts "|"}})
+		return
+		;;
+	esac
+
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "{{join .Opts " "}}" -- "$cur"))
+		return
+	fi
+	{{- if .Origins}}
+
+	local root=${PORTSDIR:-{{quote .Root}}} i
+	for ((i = 1; i < COMP_CWORD - 1; i++)); do
+		[[ ${COMP_WORDS[i]} == -R ]] && root=${COMP_WORDS[i+1]/#\~/$HOME}
+	done
+	if [[ $cur == */* ]]; then
+		COMPREPLY=($(cd "$root" 2>/dev/null && compgen -d -- "$cur"))
+	else
+		# categories are lowercase, skip Mk, Tools and the like
+		COMPREPLY=($(cd "$root" 2>/dev/null && compgen -d -S / -X '[!a-z]*' -- "$cur"))
+		compopt -o nospace
+	fi
+	{{- end}}
+}
+complete -F {{.Func}} {{.Progname}}
+`
+
+const zshCompletion = `#compdef {{.Progname}}
+# zsh completion for {{.Progname}}, generated by {{.Progname}} --completion zsh
+{{.Func}}() {
+	case ${words[CURRENT-1]} in
+	-C)
+		compadd auto always never
+		return
+		;;
+	{{join .DirOpts "|"}})
+		_files -/
+		return
+		;;
+	{{join .FileOpts "|"}})
+		_files
+		return
+		;;
+	{{join .ArgOpts "|"}})
+		return 1
+		;;
+	esac
+
+	if [[ ${words[CURRENT]} == -* ]]; then
+		compadd -- {{join .Opts " "}}
+		return
+	fi
+	{{- if .Origins}}
+
+	local root=${PORTSDIR:-{{quote .Root}}} i=${words[(I)-R]}
+	(( i > 0 && i < CURRENT - 1 )) && root=${~words[i+1]}
+	_path_files -W "$root" -/
+	{{- else}}
+	return 1
+	{{- end}}
+}
+
+if [[ $zsh_eval_context[-1] == loadautofunc ]]; then
+	{{.Func}} "$@"
+else
+	compdef {{.Func}} {{.Progname}}
+fi
+`
+
+const fishCompletion = `# fish completion for {{.Progname}}, generated by {{.Progname}} --completion fish
+complete -c {{.Progname}} -f
+{{- range .Flags}}
+complete -c {{$.Progname}} {{fishopt .}}
+{{- end}}
+complete -c {{.Progname}} -s C -x -a 'auto always never'
+{{- range .DirOpts}}
+complete -c {{$.Progname}} {{fishopt .}} -x -a '(__fish_complete_directories)'
+{{- end}}
+{{- range .FileOpts}}
+complete -c {{$.Progname}} {{fishopt .}} -r -F
+{{- end}}
+{{- range .ArgOpts}}
+complete -c {{$.Progname}} {{fishopt .}} -x
+{{- end}}
+{{- if .Origins}}
+
+function {{.Func}}_origins
+	set -l root {{quote .Root}}
+	set -q PORTSDIR; and test -n "$PORTSDIR"; and set root $PORTSDIR
+	set -l tokens (commandline -opc)
+	if set -l i (contains -i -- -R $tokens); and test $i -lt (count $tokens)
+		set root (string replace -r '^~' $HOME -- $tokens[(math $i + 1)])
+	end
+	set -l tok (commandline -ct)
+	if string match -q '*/*' -- $tok
+		set -l cat (string split -m 1 / -- $tok)[1]
+		for d in $root/$cat/*/
+			echo $cat/(basename $d)
+		end
+	else
+		# categories are lowercase, skip Mk, Tools and the like
+		for d in $root/*/
+			set -l cat (basename $d)
+			string match -qr '^[a-z]' -- $cat; and echo $cat/
+		end
+	end
+end
+complete -c {{.Progname}} -n 'not string match -q -- "-*" (commandline -ct)' -a '({{.Func}}_origins)'
+complete -c {{.Progname}} -s x -x -a '({{.Func}}_origins)'
+{{- end}}
+`
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// configPath is the location of the file with default option values.
+const configPath = "~/.portbumprc"
+
+// loadConfig sets pb defaults from key=value lines in the config file at
+// path. Blank lines and lines starting with # are ignored, a missing file is
+// not an error.
+func loadConfig(path string, pb *PortBumper) error {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected key=value", path, n)
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+
+		switch key {
+		case "ports_root":
+			if val == "" {
+				return fmt.Errorf("%s:%d: ports root cannot be blank", path, n)
+			}
+			pb.Root, err = homedir.Expand(val)
+			if err != nil {
+				return fmt.Errorf("%s:%d: error expanding ports root: %w", path, n, err)
+			}
+		case "jobs":
+			v, err := strconv.Atoi(val)
+			if err != nil || v < 1 {
+				return fmt.Errorf("%s:%d: number of jobs must be a positive integer: %s", path, n, val)
+			}
+			pb.Jobs = v
+		case "quiet":
+			v, err := strconv.ParseBool(val)
+			if err != nil {
+				return fmt.Errorf("%s:%d: quiet must be a boolean: %s", path, n, val)
+			}
+			pb.Quiet = v
+		default:
+			pb.Log.Warnf("%s:%d: unknown key %q, ignored", path, n, key)
+		}
+	}
+	return sc.Err()
+}
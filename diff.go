@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// number of context lines around each hunk
+const diffContext = 3
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line []byte
+}
+
+// unifiedDiff returns a unified diff between a and b with name used in
+// the ---/+++ headers, or nil if a and b are equal.
+func unifiedDiff(name string, a, b []byte) []byte {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", name, name)
+	writeHunks(&buf, a, b)
+	return buf.Bytes()
+}
+
+// gitPatch is unifiedDiff in git diff format, which git apply accepts, with
+// name relative to the repository root.
+func gitPatch(name string, a, b []byte) []byte {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "diff --git a/%s b/%s\n--- a/%s\n+++ b/%s\n", name, name, name, name)
+	writeHunks(&buf, a, b)
+	return buf.Bytes()
+}
+
+// writeHunks writes unified diff hunks transforming a into b to buf.
+func writeHunks(buf *bytes.Buffer, a, b []byte) {
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	// current line numbers in a and b
+	var al, bl int
+	for i := 0; i < len(ops); {
+		// skip to the next change
+		for ; i < len(ops) && ops[i].kind == ' '; i++ {
+			al++
+			bl++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		// hunk starts with up to diffContext lines of context
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		al -= i - start
+		bl -= i - start
+
+		// extend the hunk while changes are separated by less than 2*diffContext lines
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*diffContext {
+				break
+			}
+		}
+		end += diffContext
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		var an, bn int
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				an++
+			}
+			if op.kind != '-' {
+				bn++
+			}
+		}
+		fmt.Fprintf(buf, "@@ -%s +%s @@\n", diffRange(al, an), diffRange(bl, bn))
+
+		for _, op := range ops[start:end] {
+			buf.WriteByte(op.kind)
+			buf.Write(op.line)
+			if len(op.line) == 0 || op.line[len(op.line)-1] != '\n' {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		al += an
+		bl += bn
+		i = end
+	}
+}
+
+// diffRange formats a hunk range starting after line start and spanning n lines.
+func diffRange(start, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, n)
+	}
+}
+
+// diffLines computes a line edit script transforming a into b using
+// the longest common subsequence of lines.
+func diffLines(a, b [][]byte) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+
+	// common prefix and suffix don't need to go through LCS
+	var pre, suf int
+	for pre < len(a) && pre < len(b) && bytes.Equal(a[pre], b[pre]) {
+		pre++
+	}
+	for suf < len(a)-pre && suf < len(b)-pre && bytes.Equal(a[len(a)-1-suf], b[len(b)-1-suf]) {
+		suf++
+	}
+
+	for _, l := range a[:pre] {
+		ops = append(ops, diffOp{' ', l})
+	}
+
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	n, m := len(ma), len(mb)
+
+	// lcs[i][j] is the LCS length of ma[i:] and mb[j:]
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if bytes.Equal(ma[i], mb[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case bytes.Equal(ma[i], mb[j]):
+			ops = append(ops, diffOp{' ', ma[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', ma[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', mb[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', ma[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', mb[j]})
+	}
+
+	for _, l := range a[len(a)-suf:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+
+	return ops
+}
+
+// splitLines splits b into lines, keeping line terminators.
+func splitLines(b []byte) [][]byte {
+	var lines [][]byte
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			lines = append(lines, b)
+			break
+		}
+		lines = append(lines, b[:i+1])
+		b = b[i+1:]
+	}
+	return lines
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// doctor checks whether pb can do its job and prints the effective
+// configuration and labeled check results to w. Port Makefiles are never
+// touched, only a temporary file is created and removed in the sample port
+// directory. It returns false if any check failed.
+func doctor(w io.Writer, pb *PortBumper, color bool) bool {
+	op := fmt.Sprintf("adjust %s by %d", pb.Options.Target(), pb.Options.Delta)
+	switch {
+	case pb.Options.ResetRevision && !pb.Options.Epoch:
+		op = "remove PORTREVISION"
+	case pb.Options.Set:
+		op = fmt.Sprintf("set %s to %d", pb.Options.Target(), pb.Options.Value)
+	}
+	mode := "change Makefiles"
+	switch {
+	case pb.Query:
+		mode = "print current values"
+	case pb.Diff:
+		mode = "print diffs"
+	case pb.DryRun:
+		mode = "dry run"
+	}
+	maxOpen := "no limit"
+	if pb.MaxOpen > 0 {
+		maxOpen = fmt.Sprint(pb.MaxOpen)
+	}
+	timeout := "none"
+	if pb.Timeout > 0 {
+		timeout = pb.Timeout.String()
+	}
+
+	for _, kv := range [][2]string{
+		{"ports root", pb.Root},
+		{"Makefile name", pb.makefile()},
+		{"operation", op},
+		{"mode", mode},
+		{"jobs", fmt.Sprint(pb.Jobs)},
+		{"max open files", maxOpen},
+		{"retries", fmt.Sprint(pb.Retries)},
+		{"timeout", timeout},
+		{"config file", configPath},
+	} {
+		fmt.Fprintf(w, "%-16s%s\n", kv[0]+":", kv[1])
+	}
+	fmt.Fprintln(w)
+
+	ok := true
+	report := func(label, c, format string, v ...any) {
+		fmt.Fprintf(w, "%s  %s\n", colorize(label, c, color), fmt.Sprintf(format, v...))
+	}
+	pass := func(format string, v ...any) {
+		report("pass", colorGreen, format, v...)
+	}
+	fail := func(format string, v ...any) {
+		report("fail", colorRed, format, v...)
+		ok = false
+	}
+	skip := func(format string, v ...any) {
+		report("skip", colorYellow, format, v...)
+	}
+
+	rootOK := true
+	if err := pb.checkRoot(); err != nil {
+		fail("ports tree: %s", err)
+		rootOK = false
+	} else {
+		pass("ports tree: %s", pb.Root)
+	}
+
+	if _, err := loadIgnore(pb.Root); err != nil {
+		fail("ignore file: %s", err)
+	} else {
+		pass("ignore file: %s", filepath.Join(pb.Root, ignoreFile))
+	}
+
+	needGit := gitStage || gitDiff || gitCheck
+	switch path, err := exec.LookPath("git"); {
+	case err != nil && needGit:
+		fail("git: %s", err)
+	case err != nil:
+		skip("git: not found, only needed with -g, -G and -W")
+	case !rootOK:
+		skip("git: %s, ports tree not checked", path)
+	default:
+		if _, err := git(pb.Root, "rev-parse", "--is-inside-work-tree"); err != nil {
+			if needGit {
+				fail("git: ports tree is not a git repository: %s", err)
+			} else {
+				skip("git: ports tree is not a git repository, only needed with -g, -G and -W")
+			}
+		} else {
+			pass("git: %s, ports tree is a git repository", path)
+		}
+	}
+
+	if !rootOK {
+		skip("write access: ports tree not found")
+		return ok
+	}
+	origin, err := pb.samplePort()
+	switch {
+	case err != nil:
+		fail("write access: %s", err)
+	case pb.Query || pb.DryRun || pb.Diff:
+		skip("write access: %s, not needed in %s mode", origin, mode)
+	default:
+		if err := checkWritable(filepath.Join(pb.Root, origin)); err != nil {
+			fail("write access: %s: %s", origin, err)
+		} else {
+			pass("write access: %s", origin)
+		}
+	}
+	return ok
+}
+
+// samplePort returns the origin of the first port found in the ports tree,
+// taking lowercase category directories only.
+func (pb *PortBumper) samplePort() (string, error) {
+	categories, err := os.ReadDir(pb.Root)
+	if err != nil {
+		return "", err
+	}
+	for _, c := range categories {
+		name := c.Name()
+		if !c.IsDir() || name == "" || name[0] < 'a' || name[0] > 'z' {
+			continue
+		}
+		ports, err := os.ReadDir(filepath.Join(pb.Root, name))
+		if err != nil {
+			continue
+		}
+		for _, p := range ports {
+			if !p.IsDir() || strings.HasPrefix(p.Name(), ".") {
+				continue
+			}
+			origin := filepath.Join(name, p.Name())
+			if _, err := os.Stat(filepath.Join(pb.Root, origin, pb.makefile())); err == nil {
+				return origin, nil
+			}
+		}
+	}
+	return "", errors.New("no ports found")
+}
+
+// checkWritable returns an error if Makefiles in dir can't be replaced, which
+// takes creating a temporary file in dir.
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".portbump-doctor.*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
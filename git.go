@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// git runs git with args in the repository at root and returns its output.
+func git(root string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", root}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		var eerr *exec.ExitError
+		if errors.As(err, &eerr) && stderr.Len() > 0 {
+			return nil, fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// gitAdd stages makefile of origins in the repository at root.
+func gitAdd(root, makefile string, origins []string) error {
+	args := []string{"add", "--"}
+	for _, o := range origins {
+		args = append(args, filepath.Join(o, makefile))
+	}
+	_, err := git(root, args...)
+	return err
+}
+
+// gitChangedOrigins returns sorted origins of ports, directories with
+// makefile, with uncommitted changes, staged or not, in the repository at root.
+func gitChangedOrigins(root, makefile string) ([]string, error) {
+	seen := map[string]bool{}
+	var origins []string
+	for _, args := range [][]string{
+		{"diff", "--name-only", "--relative", "-z"},
+		{"diff", "--name-only", "--relative", "-z", "--cached"},
+	} {
+		out, err := git(root, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range strings.Split(string(out), "\x00") {
+			// only files in port directories are relevant
+			parts := strings.SplitN(p, "/", 3)
+			if len(parts) < 3 {
+				continue
+			}
+			o := filepath.Join(parts[0], parts[1])
+			if seen[o] {
+				continue
+			}
+			seen[o] = true
+			if _, err := os.Stat(filepath.Join(root, o, makefile)); err != nil {
+				continue
+			}
+			origins = append(origins, o)
+		}
+	}
+	sort.Strings(origins)
+	return origins, nil
+}
+
+// gitTracked reports whether port Makefiles named makefile are tracked by git,
+// listing tracked Makefiles once per category.
+type gitTracked struct {
+	root     string
+	makefile string
+	// tracked ports by category
+	categories map[string]map[string]bool
+}
+
+func newGitTracked(root, makefile string) *gitTracked {
+	return &gitTracked{root, makefile, map[string]map[string]bool{}}
+}
+
+// tracked reports whether Makefile of origin is tracked. It's false if the
+// ports tree is not a git repository.
+func (t *gitTracked) tracked(origin string) bool {
+	category, port := filepath.Split(origin)
+	ports, ok := t.categories[category]
+	if !ok {
+		ports = map[string]bool{}
+		out, err := git(filepath.Join(t.root, category), "ls-files", "-z", "--", "*/"+t.makefile)
+		if err == nil {
+			for _, p := range strings.Split(string(out), "\x00") {
+				dir, file := path.Split(p)
+				dir = strings.TrimSuffix(dir, "/")
+				// pathspec * matches subdirectories too
+				if file == t.makefile && dir != "" && !strings.Contains(dir, "/") {
+					ports[dir] = true
+				}
+			}
+		}
+		t.categories[category] = ports
+	}
+	return ports[port]
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFile is the name of the file in the ports tree root with patterns of
+// origins that are never processed in that tree.
+const ignoreFile = ".portbumpignore"
+
+// ignoreList holds filepath.Match patterns of ignored origins.
+type ignoreList []string
+
+// loadIgnore reads patterns, one per line, from the ignore file in root.
+// Blank lines and lines starting with # are ignored, a missing file is not
+// an error.
+func loadIgnore(root string) (ignoreList, error) {
+	path := filepath.Join(root, ignoreFile)
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var il ignoreList
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if _, err := filepath.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %q", path, n, line)
+		}
+		il = append(il, strings.TrimSuffix(line, "/"))
+	}
+	return il, sc.Err()
+}
+
+// match reports whether origin matches any of the patterns.
+func (il ignoreList) match(origin string) bool {
+	for _, p := range il {
+		if ok, _ := filepath.Match(p, origin); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// INDEX fields, separated by |
+const (
+	indexPkgname = 0
+	indexPath    = 1
+	indexFields  = 13
+)
+
+// dependency fields: build, run, extract, patch and fetch
+var indexDepFields = []int{7, 8, 10, 11, 12}
+
+// portIndex holds reverse dependencies of ports read from a ports INDEX file.
+type portIndex struct {
+	// package names of origins
+	pkgnames map[string]string
+	// origins of ports depending on a package
+	dependants map[string][]string
+}
+
+// readIndex reads the ports INDEX file at path.
+func readIndex(path string) (*portIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	idx, err := parseIndex(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return idx, nil
+}
+
+// parseIndex reads INDEX lines from r one at a time.
+func parseIndex(r io.Reader) (*portIndex, error) {
+	idx := &portIndex{
+		pkgnames:   map[string]string{},
+		dependants: map[string][]string{},
+	}
+
+	sc := bufio.NewScanner(r)
+	// dependency lists of some ports are very long
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Split(sc.Text(), "|")
+		if len(fields) != indexFields {
+			return nil, fmt.Errorf("line %d: expected %d fields, got %d", n, indexFields, len(fields))
+		}
+
+		p := fields[indexPath]
+		origin := path.Join(path.Base(path.Dir(p)), path.Base(p))
+		idx.pkgnames[origin] = fields[indexPkgname]
+		for _, i := range indexDepFields {
+			for _, dep := range strings.Fields(fields[i]) {
+				idx.dependants[dep] = append(idx.dependants[dep], origin)
+			}
+		}
+	}
+	return idx, sc.Err()
+}
+
+// dependantsOf returns origins of ports depending on origin, following
+// dependencies up to depth levels. ok is false if origin is not in the index.
+func (idx *portIndex) dependantsOf(origin string, depth int) (origins []string, ok bool) {
+	if _, ok := idx.pkgnames[origin]; !ok {
+		return nil, false
+	}
+
+	seen := map[string]bool{origin: true}
+	level := []string{origin}
+	for ; depth > 0 && len(level) > 0; depth-- {
+		var next []string
+		for _, o := range level {
+			for _, d := range idx.dependants[idx.pkgnames[o]] {
+				if !seen[d] {
+					seen[d] = true
+					next = append(next, d)
+				}
+			}
+		}
+		origins = append(origins, next...)
+		level = next
+	}
+	return origins, true
+}
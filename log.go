@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Level is a Logger message severity.
+type Level int
+
+const (
+	LevelError Level = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+// Logger writes messages prefixed with Prefix to W, one per line, dropping
+// messages less severe than Level. It's safe for concurrent use and a nil
+// Logger discards all messages.
+type Logger struct {
+	W      io.Writer
+	Prefix string
+	Level  Level
+	// Color errors and warnings with ANSI escapes
+	Color bool
+	// Send messages to the system logger instead of W, if set
+	Syslog sysLogger
+
+	mu sync.Mutex
+	// buffered W, see Buffer
+	buf *bufio.Writer
+}
+
+// sysLogger is the part of syslog.Writer used by Logger.
+type sysLogger interface {
+	Err(m string) error
+	Warning(m string) error
+	Info(m string) error
+	Debug(m string) error
+}
+
+// Enabled reports whether messages at level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && level <= l.Level
+}
+
+// Buffer makes l hold messages back until Flush, or until the buffer fills up.
+func (l *Logger) Buffer() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buf == nil {
+		l.buf = bufio.NewWriter(l.W)
+	}
+}
+
+// Flush writes out buffered messages.
+func (l *Logger) Flush() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buf == nil {
+		return nil
+	}
+	return l.buf.Flush()
+}
+
+// Println writes a line made of a without the prefix, whatever the level.
+func (l *Logger) Println(a ...any) {
+	if l == nil {
+		return
+	}
+	if l.Syslog != nil {
+		l.Syslog.Info(fmt.Sprint(a...))
+		return
+	}
+	l.write(fmt.Sprintln(a...))
+}
+
+func (l *Logger) Errorf(format string, v ...any) {
+	l.logf(LevelError, colorRed, format, v...)
+}
+
+func (l *Logger) Warnf(format string, v ...any) {
+	l.logf(LevelWarn, colorYellow, format, v...)
+}
+
+func (l *Logger) Infof(format string, v ...any) {
+	l.logf(LevelInfo, colorNone, format, v...)
+}
+
+func (l *Logger) Debugf(format string, v ...any) {
+	l.logf(LevelDebug, colorNone, format, v...)
+}
+
+func (l *Logger) logf(level Level, color string, format string, v ...any) {
+	if !l.Enabled(level) {
+		return
+	}
+	if l.Syslog != nil {
+		// the system logger adds its own tag
+		l.syslogf(level, format, v...)
+		return
+	}
+	msg := l.Prefix + ": " + fmt.Sprintf(format, v...)
+	l.write(colorize(msg, color, l.Color) + "\n")
+}
+
+func (l *Logger) syslogf(level Level, format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+	switch level {
+	case LevelError:
+		l.Syslog.Err(msg)
+	case LevelWarn:
+		l.Syslog.Warning(msg)
+	case LevelInfo:
+		l.Syslog.Info(msg)
+	default:
+		l.Syslog.Debug(msg)
+	}
+}
+
+func (l *Logger) write(s string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buf != nil {
+		l.buf.WriteString(s)
+	} else {
+		io.WriteString(l.W, s)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dmgk/getopt"
+)
+
+// option is a short option or, with Opt set to 0, a long option.
+type option struct {
+	getopt.Option
+	// Long option name, without the leading --
+	Long string
+}
+
+// optScanner extends getopt.Scanner with long options, given as --name,
+// --name=value or --name value. Long options can be mixed with short ones
+// but, like short ones, no options are recognized after the first argument
+// that is not an option or after --.
+type optScanner struct {
+	sc        *getopt.Scanner
+	argv0     string
+	optstring string
+	// long option names, true if an option requires an argument
+	longopts map[string]bool
+	// long option found by the last Scan
+	long *option
+	err  error
+}
+
+func newOptScanner(optstring string, longopts map[string]bool, argv []string) (*optScanner, error) {
+	sc, err := getopt.NewArgv(optstring, argv)
+	if err != nil {
+		return nil, err
+	}
+	return &optScanner{
+		sc:        sc,
+		argv0:     argv[0],
+		optstring: optstring,
+		longopts:  longopts,
+	}, nil
+}
+
+// Scan advances the scanner to the next option.
+func (s *optScanner) Scan() bool {
+	s.long = nil
+	if s.err != nil {
+		return false
+	}
+
+	prev := s.sc.Args()
+	if s.sc.Scan() {
+		return true
+	}
+	args := s.sc.Args()
+	if len(args) == 0 || len(args) < len(prev) || !strings.HasPrefix(args[0], "--") {
+		// out of arguments, stopped by -- or by a non-option argument
+		return false
+	}
+
+	name, arg, hasArg := strings.Cut(args[0][2:], "=")
+	args = args[1:]
+	needsArg, ok := s.longopts[name]
+	switch {
+	case !ok:
+		s.err = fmt.Errorf("unknown option: --%s", name)
+	case needsArg && !hasArg:
+		if len(args) == 0 {
+			s.err = fmt.Errorf("option --%s requires an argument", name)
+			break
+		}
+		arg, hasArg = args[0], true
+		args = args[1:]
+	case !needsArg && hasArg:
+		s.err = fmt.Errorf("option --%s doesn't allow an argument", name)
+	}
+
+	s.long = &option{Long: name}
+	if hasArg {
+		s.long.Arg = &arg
+	}
+	// getopt can't skip over a long option, continue with a fresh scanner
+	s.sc, _ = getopt.NewArgv(s.optstring, append([]string{s.argv0}, args...))
+	return true
+}
+
+// Option returns the option found by Scan or an error when it's an unknown
+// option or it's missing a required argument.
+func (s *optScanner) Option() (*option, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.long != nil {
+		return s.long, nil
+	}
+	opt, err := s.sc.Option()
+	if err != nil {
+		return nil, err
+	}
+	return &option{Option: *opt}, nil
+}
+
+// Args returns remaining command line arguments.
+func (s *optScanner) Args() []string {
+	return s.sc.Args()
+}
+
+// ProgramName returns basename of argv[0].
+func (s *optScanner) ProgramName() string {
+	return s.sc.ProgramName()
+}
@@ -3,49 +3,333 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"runtime/debug"
+	"sort"
 	"strconv"
-	"sync"
+	"strings"
 	"text/template"
+	"time"
 
-	"github.com/dmgk/getopt"
+	"github.com/dmgk/portbump/bump"
 	"github.com/mitchellh/go-homedir"
 )
 
 var usageTmpl = template.Must(template.New("usage").Parse(`
-usage: {{.progname}} [-hVq] [-R path] [origin ...]
+usage: {{.progname}} [-0hVADEFGJLMOWadegklnpqtvwz] [-b amount | -r revision] [-m max] [-P variable] [-T template] [-j jobs] [-N retries] [-f file] [-x origin] [-X file] [-S file] [-c reason] [-o file] [-C when] [-u depth] [-I index] [-R path | --dports] [--align column] [--count] [--doctor] [--flavors] [--force] [--makefile name] [--manifest file] [--max-failures count] [--max-open files] [--pairs] [--patch] [--pkg-query file] [--quiet-skips] [--requires dependency] [--restore] [--result-fd fd] [--sort] [--spec] [--stamp text] [--strict-placement] [--syslog] [--timeout duration] [--version-json] [--warn-broken] [origin ...]
 
 Bump port revisions.
 
 Options:
+  -0             origins read from standard input are separated by NUL
+                 characters instead of whitespace
   -h             print help and exit
   -V             print version and exit
-  -q             be quiet
+  -A             treat origins without a slash as categories and process
+                 all ports in them
+  -D             print unified diffs instead of modifying Makefiles
+  -E             stop processing new ports after the first error
+  -F             add a literal PORTREVISION after one computed from make
+                 variables instead of refusing to bump it
+  -G             process ports with uncommitted changes according to git
+                 instead of reading origins
+  -J             print results as JSON, one object per line
+  -L             origins read from standard input are the first words of
+                 lines, the rest of the line, blank lines and lines
+                 starting with # are ignored
+  -M             bump master ports instead of their slave ports
+  -O             print results in the input order
+  -W             warn about changed Makefiles not tracked by git
+  -a             change all PORTREVISION definitions in Makefiles that
+                 have more than one (e.g. in .if blocks)
+  -d             decrement PORTREVISION instead of incrementing
+  -e             change PORTEPOCH instead of PORTREVISION
+  -g             git add changed Makefiles
+  -k             keep the original Makefile as Makefile.bak
+  -l             check mode, list ports that would be changed, without
+                 changing them, and exit with status 1 if there are any
+  -n             dry run, only report what would be changed
+  -p             print current PORTREVISION (PORTEPOCH with -e) of each port
+                 instead of changing it
+  -q             be quiet, a later -v overrides it
+  -t             report elapsed time and throughput, also done with -v
+  -v             be verbose, report what was done to each port,
+                 -vv also prints debug messages, overrides quiet in
+                 ~/.portbumprc and an earlier -q
+  -w             treat skipped ports as errors
+  -z             remove PORTREVISION, e.g. after a version update,
+                 with -e remove it while changing PORTEPOCH
+  -b amount      increment PORTREVISION by amount (default: 1)
+  -r revision    set PORTREVISION to revision, 0 removes it
+  -m max         skip ports if the new PORTREVISION would exceed max
+  -P variable    add missing PORTREVISION (PORTEPOCH with -e) before the
+                 first definition of variable, e.g. CATEGORIES, instead
+                 of after DISTVERSION or PORTVERSION
+  -T template    Go template for added PORTREVISION (PORTEPOCH with -e)
+                 lines, given .Name and .Value, e.g.
+                 '{{.templateExample}}' (default: align with
+                 the line it's added after)
+  -j jobs        number of ports to process in parallel,
+                 -j 1 processes ports serially, in order (default: {{.jobs}})
+  -N retries     retry a port up to retries times after a transient
+                 filesystem error, such as EAGAIN on NFS (default: 0)
+  -f file        read origins from file, - for standard input,
+                 may be given multiple times
+  -x origin      exclude origin from processing, may be given multiple times
+  -X file        exclude origins listed in file, may be given multiple times
+  -S file        record successfully processed origins in file and skip
+                 origins already recorded there, to allow resuming a run
+  -c reason      print a commit message with reason and the list of changed
+                 ports after processing
+  -o file        write origins of changed ports to file, - for standard output
+  -C when        color output: auto, always or never (default: auto)
+  -u depth       follow dependencies of ports given with -I up to depth
+                 levels (default: 1)
+  -I index       also process ports depending on given ones, looked up
+                 in the ports INDEX file index
   -R path        ports tree root (default: {{.portsRoot}})
+  --align column put values of added PORTREVISION (PORTEPOCH with -e) lines
+                 at column, counting from 0, e.g. 16 for two tabs, padding
+                 with tabs and with spaces where a tab doesn't fit, instead
+                 of aligning with the line they are added after
+  --count        print the number of changed ports at the end, also with -q
+  --doctor       check the ports tree, git and write access to ports, print
+                 the effective configuration and exit without changing
+                 anything, with status 1 if any check failed
+  --dports       use the DragonFly dports tree, same as -R $DPORTSDIR
+  --flavors      also change per-flavor PORTREVISION (PORTEPOCH with -e)
+                 definitions of flavored ports, e.g. PORTREVISION_py39
+  --force        process ports even if the ports tree root doesn't look like
+                 one, without a Mk directory or a Makefile with SUBDIR
+  --makefile name
+                 name of port Makefiles to change (default: Makefile)
+  --manifest file
+                 read origins from file, a list of ports to bump as produced
+                 by poudriere and other builders, one origin per line, with
+                 a @flavor suffix dropped and the rest of the line, blank
+                 lines and lines starting with # ignored, may be given
+                 multiple times
+  --max-failures count
+                 stop processing new ports after more than count errors,
+                 --max-failures 0 is the same as -E
+  --max-open files
+                 maximum number of files open at once, 0 for no limit
+                 (default: {{.maxOpen}})
+  --pairs        origin lists given with -f or read from standard input are
+                 lines of origins and amounts to bump them by, the -b
+                 amount if omitted
+  --patch        same as -D, but print diffs in git diff format, with paths
+                 relative to the ports tree root, for git apply
+  --pkg-query file
+                 read origins from file, - for standard input, the output of
+                 "pkg query %o", one origin per line, with a @flavor suffix
+                 dropped and ports listed with more than one flavor
+                 processed once, may be given multiple times
+  --quiet-skips  don't report skipped ports, only changed ones and errors
+  --requires dependency
+                 skip ports without dependency, e.g. libfoo.so, in
+                 dependency lines, like LIB_DEPENDS, of their or their master
+                 port Makefile, may be given multiple times to skip ports
+                 with none of them
+  --restore      restore Makefiles of ports from Makefile.bak, as kept with -k,
+                 or Makefile.orig backups, removing the backups, instead of
+                 changing them, ports without a backup are reported as errors
+  --result-fd fd write results as JSON, one object per line, to file
+                 descriptor fd as ports are processed, in addition to
+                 the usual output
+  --sort         read all origins first and process and print them sorted
+                 by category and port name, implies -O
+  --spec         origin lists given with -f or read from standard input are
+                 JSON arrays of {"origin": ..., "action": ..., "amount": ...}
+                 objects, action is one of bump (by amount, default: 1),
+                 decrement (by amount, default: 1), set (to amount) or
+                 remove, invalid entries are reported and skipped
+  --stamp text   put a "{{.stampMarker}}text" comment on changed and added
+                 PORTREVISION (PORTEPOCH with -e) lines, replacing one
+                 left by an earlier run
+  --strict-placement
+                 warn about ports with PORTREVISION (PORTEPOCH with -e)
+                 defined before DISTVERSION or PORTVERSION or after
+                 CATEGORIES before bumping them, with -w report them as
+                 errors instead
+  --syslog       send errors, warnings and other diagnostics to the system
+                 logger instead of the standard error
+  --timeout duration
+                 report ports taking longer than duration, e.g. 30s, as
+                 errors and move on, their Makefiles may still be changed
+                 if stuck I/O completes later
+  --version-json print version, commit, build date and Go version as JSON
+                 and exit
+  --warn-broken  warn about ports with DEPRECATED, EXPIRATION_DATE or BROKEN
+                 set before bumping them, with -w report them as errors
+                 instead
 
 Arguments:
   category/port  port origin(s) to bump PORTREVISION of
 
   Alternatively, pipe a space separated origin list
   (e.g. from "portgrep -1") to the {{.progname}} standard input.
+  Origins given as arguments and read with -f are combined, as are
+  arguments and origins piped or redirected from a file to the
+  standard input when -f is not given.
+  Origins containing shell wildcards (e.g. "www/*") are expanded
+  to ports under the ports tree root, quote them to keep the shell
+  from expanding them. Origins without a category given while in
+  a category directory of the ports tree, or deeper, are taken to be
+  ports in that category.
+
+Environment:
+  PORTSDIR       default ports tree root
+  DPORTSDIR      default ports tree root if PORTSDIR is not set and dports
+                 tree root with --dports (default: {{.dportsRoot}})
+  PORTBUMP_JOBS  default number of ports to process in parallel
+
+Files:
+  {{.configPath}}  default ports_root, jobs and quiet settings as key=value
+                 lines, command line options override them and they
+                 override the environment
+  {{.ignoreFile}}
+                 shell patterns of origins to never process, one per
+                 line, in the ports tree root
+
+Output:
+  Origins of changed ports are printed to the standard output, with
+  a + suffix if PORTREVISION (PORTEPOCH with -e) was added and a - suffix
+  if it was removed.
+
+  If the standard error is a terminal, a progress line is shown on it
+  when the number of origins is known in advance, for origins given as
+  arguments, with -G or with --sort, unless -q is given.
+
+Exit status:
+  0              at least one port was changed (would be with -n or -D)
+  1              there were errors or, with -l, there are ports to change
+  2              no ports were changed and there were no errors, with -l and
+                 -p the status is 0 instead
 `[1:]))
 
+// DragonFly dports tree root
+const defaultDportsRoot = "/usr/dports"
+
+// make variable names accepted by -P
+var varNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// short options, in getopt format
+const optString = "0hVADEFGJLMOWadegklnpqtvwzb:r:m:P:T:j:N:f:x:X:S:c:o:C:u:I:R:"
+
+// long options, true if an option requires an argument
+var longOptions = map[string]bool{
+	"align":              true,
+	"completion":         true,
+	"completion-origins": false,
+	"count":              false,
+	"doctor":             false,
+	"dports":             false,
+	"flavors":            false,
+	"force":              false,
+	"makefile":           true,
+	"manifest":           true,
+	"max-failures":       true,
+	"max-open":           true,
+	"pairs":              false,
+	"patch":              false,
+	"pkg-query":          true,
+	"requires":           true,
+	"quiet-skips":        false,
+	"restore":            false,
+	"result-fd":          true,
+	"sort":               false,
+	"spec":               false,
+	"stamp":              true,
+	"strict-placement":   false,
+	"syslog":             false,
+	"timeout":            true,
+	"version-json":       false,
+	"warn-broken":        false,
+}
+
 var (
-	progname  string
-	portsRoot = "/usr/ports"
-	quiet     bool
-	version   = "devel"
+	progname string
+	jsonOut  bool
+	// JSON results destination, stdout with -J
+	resultFile *os.File
+	ordered    bool
+	// process and print origins sorted, after reading all of them
+	sortOrigins bool
+	nulSep      bool
+	// origin lists are lines of origins and bump amounts
+	pairs bool
+	// origin lists are JSON arrays of origins and changes to make
+	spec bool
+	// skip the ports tree root check
+	forceRoot bool
+	// print the number of changed ports, even with -q
+	printCount bool
+	// don't report skipped ports
+	quietSkips bool
+	// list ports that would be changed and fail if there are any
+	checkMode bool
+	// read the first word of each standard input line
+	lineMode bool
+	verbose  int
+	// expand bare category names to all ports in them
+	categories bool
+	// processed origins state file given with -S
+	state *stateFile
+	// commit message reason given with -c
+	commitReason string
+	// stage changed Makefiles with git
+	gitStage bool
+	// changed origins list file given with -o
+	changedList *os.File
+	// read origins from git diff
+	gitDiff bool
+	// warn about Makefiles not tracked by git
+	gitCheck bool
+	// report elapsed time and throughput
+	timing bool
+	// dependency levels to follow with -u
+	depDepth int
+	// shell to print a completion script for
+	completionShell string
+	// also complete port origins in the completion script
+	completionOrigins bool
+	// send diagnostics to the system logger
+	useSyslog bool
+	// check the environment and configuration instead of processing ports
+	runDoctor bool
+	// progress line shown on a terminal, nil if it's not shown
+	prog *progress
+	// when to color output
+	colorMode = "auto"
+	// set with -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+	version = "devel"
+	commit  string
+	date    string
 )
 
-func showUsage() {
+func showUsage(pb *PortBumper) {
 	err := usageTmpl.Execute(os.Stdout, map[string]interface{}{
-		"progname":  progname,
-		"portsRoot": portsRoot,
+		"progname":    progname,
+		"portsRoot":   pb.Root,
+		"jobs":        pb.Jobs,
+		"maxOpen":     pb.MaxOpen,
+		"configPath":  configPath,
+		"dportsRoot":  defaultDportsRoot,
+		"ignoreFile":  ignoreFile,
+		"stampMarker": bump.StampMarker,
+		// literal template syntax can't be written in the usage template
+		"templateExample": `{{.Name}}={{"\t"}}{{.Value}}`,
 	})
 	if err != nil {
 		panic(fmt.Sprintf("error executing template %q: %s", usageTmpl.Name(), err))
@@ -56,6 +340,29 @@ func showVersion() {
 	fmt.Printf("%s %s\n", progname, version)
 }
 
+// showVersionJSON prints version, commit and build date, falling back to
+// the commit and its date recorded by go build, and the Go version as JSON.
+func showVersionJSON() {
+	v := struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		Commit  string `json:"commit,omitempty"`
+		Date    string `json:"date,omitempty"`
+		Go      string `json:"go"`
+	}{progname, version, commit, date, runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && v.Commit == "":
+				v.Commit = s.Value
+			case s.Key == "vcs.time" && v.Date == "":
+				v.Date = s.Value
+			}
+		}
+	}
+	json.NewEncoder(os.Stdout).Encode(v)
+}
+
 func errExit(format string, v ...any) {
 	fmt.Fprint(os.Stderr, progname, ": ")
 	fmt.Fprintf(os.Stderr, format, v...)
@@ -64,15 +371,61 @@ func errExit(format string, v ...any) {
 }
 
 func main() {
+	pb := &PortBumper{
+		Root:    "/usr/ports",
+		Jobs:    runtime.NumCPU(),
+		Options: bump.Options{Delta: 1},
+	}
+	if n := openFilesLimit(); n > 0 {
+		// leave some for standard streams, lists, state and index files and git
+		pb.MaxOpen = n - 32
+		if pb.MaxOpen < 3 {
+			pb.MaxOpen = 3
+		}
+	}
+
+	// PORTSDIR takes precedence over DPORTSDIR
+	dportsRoot := defaultDportsRoot
+	if v, ok := os.LookupEnv("DPORTSDIR"); ok && v != "" {
+		dportsRoot = v
+		pb.Root = v
+	}
 	if v, ok := os.LookupEnv("PORTSDIR"); ok && v != "" {
-		portsRoot = v
+		pb.Root = v
 	}
 
-	opts, err := getopt.New("hVqR:")
+	opts, err := newOptScanner(optString, longOptions, os.Args)
 	if err != nil {
 		panic(fmt.Sprintf("error creating options parser: %s", err))
 	}
 	progname = opts.ProgramName()
+	pb.Log = &Logger{W: os.Stderr, Prefix: progname, Level: LevelInfo}
+
+	if v, ok := os.LookupEnv("PORTBUMP_JOBS"); ok && v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			errExit("PORTBUMP_JOBS must be a positive integer: %s", v)
+		}
+		pb.Jobs = n
+	}
+
+	// config file overrides environment, command line options override both
+	if err := loadConfig(configPath, pb); err != nil {
+		errExit("error reading config: %s", err)
+	}
+
+	// option that last set the revision operation
+	var opOpt byte
+	// origin lists given with -f, --manifest and --pkg-query
+	var lists []*os.File
+	// lists given with --manifest
+	manifests := map[*os.File]bool{}
+	// lists given with --pkg-query
+	pkgQueries := map[*os.File]bool{}
+	// origins given with -x or read from -X files, normalized after -R is known
+	var excludes []string
+	// INDEX path given with -I
+	var indexPath string
 
 	for opts.Scan() {
 		opt, err := opts.Option()
@@ -81,169 +434,1253 @@ func main() {
 		}
 
 		switch opt.Opt {
+		case 'b', 'd', 'r':
+			if opOpt != 0 && opOpt != opt.Opt {
+				errExit("-%c and -%c are mutually exclusive", opOpt, opt.Opt)
+			}
+			opOpt = opt.Opt
+		}
+
+		switch opt.Opt {
+		case '0':
+			nulSep = true
 		case 'h':
-			showUsage()
+			showUsage(pb)
 			os.Exit(0)
 		case 'V':
 			showVersion()
 			os.Exit(0)
+		case 'A':
+			categories = true
+		case 'D':
+			pb.Diff = true
+		case 'b':
+			v, err := opt.Int()
+			if err != nil || v < 1 {
+				errExit("bump amount must be a positive integer: %s", opt.String())
+			}
+			pb.Options.Delta = v
+		case 'E':
+			pb.FailFast = true
+			pb.MaxFailures = 0
+		case 'F':
+			pb.Options.Force = true
+		case 'G':
+			gitDiff = true
+		case 'J':
+			jsonOut = true
+		case 'L':
+			lineMode = true
+		case 'M':
+			pb.FollowMaster = true
+		case 'O':
+			ordered = true
+		case 'W':
+			gitCheck = true
+		case 'a':
+			pb.Options.All = true
+		case 'd':
+			pb.Options.Delta = -1
+		case 'e':
+			pb.Options.Epoch = true
+		case 'g':
+			gitStage = true
+		case 'k':
+			pb.Backup = true
+		case 'l':
+			checkMode = true
+		case 'n':
+			pb.DryRun = true
+		case 'p':
+			pb.Query = true
 		case 'q':
-			quiet = true
+			pb.Quiet = true
+			verbose = 0
+		case 't':
+			timing = true
+		case 'v':
+			// also overrides quiet set in the config file
+			verbose++
+			pb.Quiet = false
+		case 'w':
+			pb.Strict = true
+		case 'z':
+			pb.Options.ResetRevision = true
+		case 'r':
+			v, err := opt.Uint64()
+			if err != nil {
+				errExit("revision must be a non-negative integer: %s", opt.String())
+			}
+			pb.Options.Set = true
+			pb.Options.Value = v
+		case 'm':
+			v, err := opt.Uint64()
+			if err != nil || v < 1 {
+				errExit("maximum revision must be a positive integer: %s", opt.String())
+			}
+			pb.Options.Max = v
+		case 'P':
+			if !varNameRe.MatchString(opt.String()) {
+				errExit("invalid variable name: %s", opt.String())
+			}
+			pb.Options.Before = opt.String()
+		case 'T':
+			pb.Options.Template, err = template.New("-T").Parse(opt.String())
+			if err != nil {
+				errExit("invalid template: %s", err)
+			}
+		case 'j':
+			v, err := opt.Int()
+			if err != nil || v < 1 {
+				errExit("number of jobs must be a positive integer: %s", opt.String())
+			}
+			pb.Jobs = v
+		case 'N':
+			v, err := opt.Int()
+			if err != nil || v < 0 {
+				errExit("number of retries must be a non-negative integer: %s", opt.String())
+			}
+			pb.Retries = v
+		case 'f':
+			if opt.String() == "-" {
+				lists = append(lists, os.Stdin)
+				break
+			}
+			f, err := os.Open(opt.String())
+			if err != nil {
+				errExit("error opening origin list: %s", err)
+			}
+			lists = append(lists, f)
+		case 'x':
+			excludes = append(excludes, opt.String())
+		case 'X':
+			f, err := os.Open(opt.String())
+			if err != nil {
+				errExit("error opening exclusion list: %s", err)
+			}
+			err = scanOrigins(f, bufio.ScanWords, func(o string) bool {
+				excludes = append(excludes, o)
+				return true
+			})
+			f.Close()
+			if err != nil {
+				errExit("error reading %s: %s", f.Name(), err)
+			}
+		case 'S':
+			state, err = openState(opt.String())
+			if err != nil {
+				errExit("error opening state file: %s", err)
+			}
+		case 'c':
+			commitReason = opt.String()
+			if commitReason == "" {
+				errExit("commit reason cannot be blank")
+			}
+		case 'o':
+			if opt.String() == "-" {
+				changedList = os.Stdout
+				break
+			}
+			changedList, err = os.Create(opt.String())
+			if err != nil {
+				errExit("error creating changed origins list: %s", err)
+			}
+		case 'C':
+			switch opt.String() {
+			case "auto", "always", "never":
+				colorMode = opt.String()
+			default:
+				errExit("color mode must be auto, always or never: %s", opt.String())
+			}
+		case 'u':
+			v, err := opt.Int()
+			if err != nil || v < 1 {
+				errExit("dependency depth must be a positive integer: %s", opt.String())
+			}
+			depDepth = v
+		case 'I':
+			indexPath = opt.String()
 		case 'R':
 			arg := opt.String()
 			if arg != "" {
-				portsRoot, err = homedir.Expand(arg)
+				pb.Root, err = homedir.Expand(arg)
 				if err != nil {
 					errExit("error expanding ports root: %s", err.Error())
 				}
 			} else {
 				errExit("ports root cannot be blank")
 			}
+		case 0:
+			switch opt.Long {
+			case "align":
+				n, err := opt.Int()
+				if err != nil || n < 1 {
+					errExit("alignment column must be a positive integer: %s", opt.String())
+				}
+				pb.Options.Align = n
+			case "completion":
+				completionShell = opt.String()
+			case "completion-origins":
+				completionOrigins = true
+			case "count":
+				printCount = true
+			case "flavors":
+				pb.Options.Flavors = true
+			case "force":
+				forceRoot = true
+			case "doctor":
+				runDoctor = true
+			case "dports":
+				pb.Root = dportsRoot
+			case "pairs":
+				pairs = true
+			case "patch":
+				pb.Diff = true
+				pb.GitDiff = true
+			case "restore":
+				pb.Restore = true
+			case "result-fd":
+				fd, err := opt.Int()
+				if err != nil || fd < 0 {
+					errExit("result descriptor must be a non-negative integer: %s", opt.String())
+				}
+				resultFile = os.NewFile(uintptr(fd), "fd "+opt.String())
+				if _, err := resultFile.Stat(); err != nil {
+					errExit("invalid result descriptor: %s", err)
+				}
+			case "makefile":
+				name := opt.String()
+				if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+					errExit("Makefile name must be a plain file name: %s", name)
+				}
+				pb.Makefile = name
+			case "manifest":
+				f, err := os.Open(opt.String())
+				if err != nil {
+					errExit("error opening manifest: %s", err)
+				}
+				lists = append(lists, f)
+				manifests[f] = true
+			case "pkg-query":
+				if opt.String() == "-" {
+					lists = append(lists, os.Stdin)
+					pkgQueries[os.Stdin] = true
+					break
+				}
+				f, err := os.Open(opt.String())
+				if err != nil {
+					errExit("error opening pkg query output: %s", err)
+				}
+				lists = append(lists, f)
+				pkgQueries[f] = true
+			case "max-failures":
+				n, err := opt.Int()
+				if err != nil || n < 0 {
+					errExit("maximum number of failures must be a non-negative integer: %s", opt.String())
+				}
+				pb.FailFast = true
+				pb.MaxFailures = n
+			case "max-open":
+				n, err := opt.Int()
+				if err != nil || n < 0 {
+					errExit("maximum number of open files must be a non-negative integer: %s", opt.String())
+				}
+				pb.MaxOpen = n
+			case "quiet-skips":
+				quietSkips = true
+			case "requires":
+				if opt.String() == "" {
+					errExit("required dependency cannot be blank")
+				}
+				pb.Requires = append(pb.Requires, opt.String())
+			case "sort":
+				sortOrigins = true
+				ordered = true
+			case "spec":
+				spec = true
+			case "stamp":
+				switch v := opt.String(); {
+				case strings.TrimSpace(v) == "":
+					errExit("stamp cannot be blank")
+				case strings.ContainsAny(v, "\r\n"), strings.HasSuffix(v, "\\"):
+					errExit("stamp must be a single line not ending with a backslash: %q", v)
+				default:
+					pb.Options.Stamp = v
+				}
+			case "strict-placement":
+				pb.StrictPlacement = true
+			case "syslog":
+				useSyslog = true
+			case "version-json":
+				showVersionJSON()
+				os.Exit(0)
+			case "warn-broken":
+				pb.WarnBroken = true
+			case "timeout":
+				d, err := time.ParseDuration(opt.String())
+				if err != nil || d <= 0 {
+					errExit("timeout must be a positive duration: %s", opt.String())
+				}
+				pb.Timeout = d
+			default:
+				panic("unhandled option: --" + opt.Long)
+			}
 		default:
 			panic("unhandled option: -" + string(opt.Opt))
 		}
 	}
 
-	origch := make(chan string)
-	donech := make(chan bool)
+	if completionShell != "" {
+		err := writeCompletion(os.Stdout, completionShell, optString, longOptions, pb.Root, completionOrigins)
+		if err != nil {
+			errExit("error writing completion script: %s", err)
+		}
+		os.Exit(0)
+	}
+	if completionOrigins {
+		errExit("--completion-origins requires --completion")
+	}
+
+	if checkMode {
+		switch {
+		case pb.Query:
+			errExit("-l and -p are mutually exclusive")
+		case pb.Diff:
+			errExit("-l and -D are mutually exclusive")
+		}
+		pb.DryRun = true
+	}
+	if pb.Restore {
+		switch {
+		case pb.Query:
+			errExit("-p and --restore are mutually exclusive")
+		case pb.Diff:
+			errExit("-D and --restore are mutually exclusive")
+		case checkMode:
+			errExit("-l and --restore are mutually exclusive")
+		case pb.Backup:
+			errExit("-k and --restore are mutually exclusive")
+		}
+	}
+	if nulSep && lineMode {
+		errExit("-0 and -L are mutually exclusive")
+	}
+	if pairs {
+		switch {
+		case opOpt != 0 && opOpt != 'b':
+			errExit("-%c and --pairs are mutually exclusive", opOpt)
+		case pb.Options.ResetRevision:
+			errExit("-z and --pairs are mutually exclusive")
+		case nulSep:
+			errExit("-0 and --pairs are mutually exclusive")
+		case lineMode:
+			errExit("-L and --pairs are mutually exclusive")
+		}
+	}
+	if spec {
+		switch {
+		case opOpt != 0:
+			errExit("-%c and --spec are mutually exclusive", opOpt)
+		case pb.Options.ResetRevision:
+			errExit("-z and --spec are mutually exclusive")
+		case pairs:
+			errExit("--pairs and --spec are mutually exclusive")
+		case nulSep:
+			errExit("-0 and --spec are mutually exclusive")
+		case lineMode:
+			errExit("-L and --spec are mutually exclusive")
+		}
+	}
+	if jsonOut {
+		if resultFile != nil {
+			errExit("-J and --result-fd are mutually exclusive")
+		}
+		resultFile = os.Stdout
+	}
+
+	switch {
+	case pb.Quiet:
+		pb.Log.Level = LevelError
+	case verbose > 1:
+		pb.Log.Level = LevelDebug
+	}
+	pb.Log.Color = useColor(colorMode, os.Stderr) && !pb.Quiet
+	if !pb.Quiet && isTerminal(os.Stderr) {
+		prog = &progress{w: os.Stderr}
+	}
+	if useSyslog {
+		pb.Log.Syslog, err = openSyslog(progname)
+		if err != nil {
+			errExit("error connecting to syslog: %s", err)
+		}
+	}
 
-	go processOrigins(origch, donech)
+	if pb.Options.ResetRevision && !pb.Options.Epoch && opOpt != 0 {
+		errExit("-%c and -z are mutually exclusive", opOpt)
+	}
 
-	origins := opts.Args()
-	if len(origins) > 0 {
-		// process origins given on the command line
-		for _, o := range origins {
-			origch <- o
+	if pb.Options.Template != nil && pb.Options.Align > 0 {
+		errExit("-T and --align are mutually exclusive")
+	}
+	if t := pb.Options.Template; t != nil {
+		// catch execution errors, like unknown fields, before touching any files
+		err := t.Execute(io.Discard, bump.TemplateData{Name: pb.Options.Target(), Value: 1})
+		if err != nil {
+			errExit("invalid template: %s", err)
 		}
-	} else {
-		// no origins were given as arguments, read from stdin
-		sc := bufio.NewScanner(os.Stdin)
-		sc.Split(bufio.ScanWords)
-		for sc.Scan() {
-			origch <- sc.Text()
+	}
+
+	if depDepth > 0 && indexPath == "" {
+		errExit("-u requires -I")
+	}
+	if indexPath != "" && depDepth == 0 {
+		depDepth = 1
+	}
+	var idx *portIndex
+	if indexPath != "" {
+		idx, err = readIndex(indexPath)
+		if err != nil {
+			errExit("error reading index: %s", err)
 		}
 	}
 
-	close(origch)
-	<-donech
-}
+	if runDoctor {
+		if !doctor(os.Stdout, pb, useColor(colorMode, os.Stdout)) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
-type result struct {
-	origin string
-	err    error
-}
+	if !forceRoot {
+		if err := pb.checkRoot(); err != nil {
+			errExit("%s, use --force to process it anyway", err)
+		}
+	}
 
-func processOrigins(origch chan string, donech chan bool) {
-	defer close(donech)
+	// bare port names given in a category directory are ports in it, unless
+	// -A makes them categories
+	if !categories {
+		pb.DefaultCategory = pb.workingCategory()
+	}
+
+	excluded := map[string]bool{}
+	for _, o := range excludes {
+		excluded[pb.normalizeOrigin(o)] = true
+	}
+	ignored, err := loadIgnore(pb.Root)
+	if err != nil {
+		errExit("error reading ignore file: %s", err)
+	}
 
-	resch := make(chan result)
-	sem := make(chan int, runtime.NumCPU())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	// reading origins is also cancelled on the first error with -E
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	origch := make(chan Job)
+	donech := make(chan tally)
+
+	go processOrigins(ctx, cancel, pb, origch, donech)
+
+	// --pairs or --spec input had malformed lines or entries, they are skipped
+	var malformed bool
+	// an origin list couldn't be read, ports read before are still processed
+	var readFailed bool
+
+	origins := opts.Args()
+	if gitDiff {
+		if len(origins) > 0 || len(lists) > 0 {
+			errExit("-G cannot be combined with origin arguments or -f")
+		}
+		origins, err = gitChangedOrigins(pb.Root, pb.makefile())
+		if err != nil {
+			errExit("error getting changed ports: %s", err)
+		}
+	} else if len(lists) == 0 && (len(origins) == 0 || isPipe(os.Stdin)) {
+		// no origins were given or more are piped in addition to arguments,
+		// read from stdin
+		lists = append(lists, os.Stdin)
+	}
 
 	go func() {
-		defer close(resch)
+		defer close(origch)
 
-		var wg sync.WaitGroup
-		for o := range origch {
-			sem <- 1
-			wg.Add(1)
+		seen := map[string]bool{}
+		// flavors of flavored origins read with --pkg-query, by port
+		pkgFlavors := map[string]string{}
+		// origins collected for sorting with --sort or to count them for
+		// the progress line, which is only possible if they aren't read
+		// from lists
+		collect := sortOrigins || prog != nil && len(lists) == 0
+		var queue []Job
+		// sendOrigin returns false when no more origins should be sent
+		sendOrigin := func(j Job) bool {
+			o := j.Origin
+			if seen[o] {
+				pb.Log.Warnf("%s: duplicate origin, skipped", o)
+				return true
+			}
+			seen[o] = true
+			if excluded[o] {
+				pb.Log.Infof("%s: excluded", o)
+				return true
+			}
+			if ignored.match(o) {
+				pb.Log.Infof("%s: ignored by %s", o, ignoreFile)
+				return true
+			}
+			if state != nil && state.done[o] {
+				pb.Log.Infof("%s: already processed, skipped", o)
+				return true
+			}
+			if collect {
+				queue = append(queue, j)
+				return true
+			}
+			select {
+			case origch <- j:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		// sendDependants sends j and, with -u, the same job for ports
+		// depending on its origin
+		sendDependants := func(j Job) bool {
+			if !sendOrigin(j) {
+				return false
+			}
+			if idx == nil {
+				return true
+			}
+			o := j.Origin
+			deps, ok := idx.dependantsOf(o, depDepth)
+			if ok {
+				pb.Log.Infof("%s: %d dependent ports", o, len(deps))
+			} else {
+				pb.Log.Warnf("%s: not found in index", o)
+			}
+			for _, d := range deps {
+				j.Origin = d
+				if !sendOrigin(j) {
+					return false
+				}
+			}
+			return true
+		}
+		// send expands the job origin if it's a glob pattern or, with -A,
+		// a category and sends the job for resulting origins
+		send := func(j Job) bool {
+			o := pb.normalizeOrigin(j.Origin)
+			j.Origin = o
+			category := categories && !strings.Contains(o, "/")
+			if !category && !isGlob(o) {
+				return sendDependants(j)
+			}
+			pattern := o
+			if category {
+				pattern = filepath.Join(o, "*")
+			}
+			matches, err := pb.expandGlob(pattern)
+			if err != nil {
+				pb.Log.Errorf("%s: %s", o, err)
+				return true
+			}
+			switch {
+			case len(matches) == 0:
+				pb.Log.Warnf("%s: no ports match", o)
+			case category:
+				pb.Log.Infof("%s: %d ports", o, len(matches))
+			}
+			for _, m := range matches {
+				j.Origin = m
+				if !sendDependants(j) {
+					return false
+				}
+			}
+			return true
+		}
+
+		// process origins given on the command line
+		for _, o := range origins {
+			if !send(Job{Origin: o}) {
+				return
+			}
+		}
+	lists:
+		for _, f := range lists {
+			if spec && !manifests[f] && !pkgQueries[f] {
+				err := scanSpec(f, pb.Options, send, func(n int, msg string) {
+					pb.Log.Errorf("%s: entry %d: %s", f.Name(), n, msg)
+					malformed = true
+				})
+				f.Close()
+				if err != nil {
+					pb.Log.Errorf("error reading %s: %s", f.Name(), err)
+					readFailed = true
+					break lists
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				continue
+			}
+			if pairs && !manifests[f] && !pkgQueries[f] {
+				err := scanPairs(f, func(o string, delta int) bool {
+					return send(Job{Origin: o, Delta: delta})
+				}, func(n int, msg string) {
+					pb.Log.Errorf("%s:%d: %s", f.Name(), n, msg)
+					malformed = true
+				})
+				f.Close()
+				if err != nil {
+					pb.Log.Errorf("error reading %s: %s", f.Name(), err)
+					readFailed = true
+					break lists
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				continue
+			}
+
+			split := bufio.ScanWords
+			switch {
+			case manifests[f], pkgQueries[f]:
+				split = scanFirstWords
+			case nulSep && f == os.Stdin:
+				split = scanNul
+			case lineMode && f == os.Stdin:
+				split = scanFirstWords
+			}
+			err := scanOrigins(f, split, func(o string) bool {
+				if manifests[f] {
+					// flavored origins, as in poudriere lists, are ports
+					o, _, _ = strings.Cut(o, "@")
+				}
+				if pkgQueries[f] {
+					// a port is processed once for all of its flavors
+					port, flavor, _ := strings.Cut(o, "@")
+					if prev, dup := pkgFlavors[port]; dup && (flavor != "" || prev != "") {
+						pb.Log.Debugf("%s: already listed with another flavor, skipped", o)
+						return true
+					}
+					pkgFlavors[port] = flavor
+					if flavor != "" {
+						pb.Log.Debugf("%s: flavor %s", port, flavor)
+					}
+					o = port
+				}
+				return send(Job{Origin: o})
+			})
+			f.Close()
+			if err != nil {
+				// let processOrigins finish the ports already sent
+				pb.Log.Errorf("error reading %s: %s", f.Name(), err)
+				readFailed = true
+				break lists
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
 
-			go func(o string) {
-				defer func() {
-					<-sem
-					wg.Done()
-				}()
-				resch <- result{
-					o,
-					processPort(filepath.Join(portsRoot, o, "Makefile")),
+		if sortOrigins {
+			sort.SliceStable(queue, func(i, j int) bool {
+				return originLess(queue[i].Origin, queue[j].Origin)
+			})
+		}
+		if collect {
+			prog.setTotal(len(queue))
+			for _, j := range queue {
+				select {
+				case origch <- j:
+				case <-ctx.Done():
+					return
 				}
-			}(o)
+			}
 		}
-		wg.Wait()
 	}()
 
-	for res := range resch {
-		if res.err != nil {
-			fmt.Fprintf(os.Stderr, "%s: %s: %s\n", progname, res.origin, res.err)
-			continue
+	// don't wait for the origin reader, it may be blocked on input after an interrupt
+	t := <-donech
+	if printCount {
+		noun, verb := "ports", "changed"
+		if t.changed() == 1 {
+			noun = "port"
+		}
+		if pb.DryRun {
+			verb = "would change"
 		}
-		if !quiet {
-			fmt.Println(res.origin)
+		fmt.Fprintf(os.Stderr, "%d %s %s\n", t.changed(), noun, verb)
+	}
+	switch {
+	case t.failed > 0 || ctx.Err() != nil || malformed || readFailed:
+		os.Exit(1)
+	case checkMode:
+		if t.changed() > 0 {
+			os.Exit(1)
 		}
+	case !pb.Query && t.changed() == 0:
+		// nothing to do, let scripts skip committing
+		os.Exit(2)
 	}
 }
 
-func processPort(makefilePath string) error {
-	f, err := os.OpenFile(makefilePath, os.O_RDWR, 0644)
-	if err != nil {
-		return err
+// originLess reports whether origin a sorts before b, comparing categories
+// first and then port names.
+func originLess(a, b string) bool {
+	acat, aport, _ := strings.Cut(a, "/")
+	bcat, bport, _ := strings.Cut(b, "/")
+	if acat != bcat {
+		return acat < bcat
 	}
-	defer f.Close()
+	return aport < bport
+}
 
-	fi, err := f.Stat()
-	if err != nil {
-		return err
+// scanOrigins calls send for each origin read from r and separated according
+// to split, until send returns false.
+func scanOrigins(r io.Reader, split bufio.SplitFunc, send func(string) bool) error {
+	sc := bufio.NewScanner(r)
+	sc.Split(split)
+	for sc.Scan() {
+		if sc.Text() != "" && !send(sc.Text()) {
+			break
+		}
 	}
+	return sc.Err()
+}
 
-	fbuf := bufGet()
-	defer bufPut(fbuf)
+// scanPairs calls send for each origin and bump amount read from r, until
+// send returns false. Each line of r is an origin optionally followed by an
+// amount, which is 0 if it's missing. Comments starting with # and blank
+// lines are ignored, malformed lines are passed to report with their line
+// numbers.
+func scanPairs(r io.Reader, send func(string, int) bool, report func(int, string)) error {
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var amount int
+		switch len(fields) {
+		case 1:
+		case 2:
+			v, err := strconv.Atoi(fields[1])
+			if err != nil || v < 1 {
+				report(n, "bump amount must be a positive integer: "+fields[1])
+				continue
+			}
+			amount = v
+		default:
+			report(n, "expected origin and bump amount")
+			continue
+		}
+		if !send(fields[0], amount) {
+			break
+		}
+	}
+	return sc.Err()
+}
 
-	fbuf.Grow(int(fi.Size()) + bytes.MinRead)
-	_, err = fbuf.ReadFrom(f)
-	if err != nil {
-		return err
+// scanNul is a bufio.SplitFunc that splits input on NUL characters.
+func scanNul(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
 	}
+	return 0, nil, nil
+}
 
-	buf, err := bumpPortrevision(fbuf.Bytes())
-	if err != nil {
-		return err
+// scanFirstWords is a bufio.SplitFunc that returns the first word of each
+// line, and empty tokens for blank lines and lines starting with #.
+func scanFirstWords(data []byte, atEOF bool) (int, []byte, error) {
+	n, line, err := bufio.ScanLines(data, atEOF)
+	if n == 0 || err != nil {
+		return n, line, err
 	}
+	words := bytes.Fields(line)
+	if len(words) == 0 || words[0][0] == '#' {
+		return n, []byte{}, nil
+	}
+	return n, words[0], nil
+}
 
-	_, err = f.Seek(0, 0)
-	if err != nil {
+// specEntry is an element of the --spec JSON array.
+type specEntry struct {
+	Origin string `json:"origin"`
+	// bump, decrement, set or remove
+	Action string `json:"action"`
+	Amount *int   `json:"amount"`
+}
+
+// scanSpec calls send for each entry of the JSON array of specEntry objects
+// read from r, until send returns false. Job options are opts with the entry
+// action applied. Invalid entries are passed to report with their positions
+// in the array, starting with 1.
+func scanSpec(r io.Reader, opts bump.Options, send func(Job) bool, report func(int, string)) error {
+	dec := json.NewDecoder(r)
+	if t, err := dec.Token(); err != nil {
 		return err
+	} else if t != json.Delim('[') {
+		return errors.New("expected a JSON array")
 	}
+	for n := 1; dec.More(); n++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		if raw[0] != '{' {
+			report(n, "expected an object")
+			continue
+		}
+		var e specEntry
+		ed := json.NewDecoder(bytes.NewReader(raw))
+		ed.DisallowUnknownFields()
+		if err := ed.Decode(&e); err != nil {
+			report(n, "invalid entry: "+err.Error())
+			continue
+		}
+		if e.Origin == "" {
+			report(n, "missing origin")
+			continue
+		}
 
-	_, err = f.Write(buf)
+		o := opts
+		o.Delta, o.Set, o.Value = 1, false, 0
+		switch e.Action {
+		case "bump", "decrement":
+			if e.Amount != nil {
+				if *e.Amount < 1 {
+					report(n, fmt.Sprintf("%s: amount must be a positive integer: %d", e.Origin, *e.Amount))
+					continue
+				}
+				o.Delta = *e.Amount
+			}
+			if e.Action == "decrement" {
+				o.Delta = -o.Delta
+			}
+		case "set":
+			if e.Amount == nil || *e.Amount < 0 {
+				report(n, e.Origin+": set needs a non-negative amount")
+				continue
+			}
+			o.Set, o.Value = true, uint64(*e.Amount)
+		case "remove":
+			if e.Amount != nil {
+				report(n, e.Origin+": remove doesn't take an amount")
+				continue
+			}
+			o.Set = true
+		default:
+			report(n, fmt.Sprintf("%s: action must be bump, decrement, set or remove: %q", e.Origin, e.Action))
+			continue
+		}
+		if !send(Job{Origin: e.Origin, Options: &o}) {
+			return nil
+		}
+	}
+	_, err := dec.Token()
 	return err
 }
 
-var (
-	distversionRe  = regexp.MustCompile(`((?:\A|\n)\s*DISTVERSION\s*\??=.*(?:\n|\z))`)
-	portversionRe  = regexp.MustCompile(`((?:\A|\n)\s*PORTVERSION\s*\??=.*(?:\n|\z))`)
-	portrevisionRe = regexp.MustCompile(`((?:\A|\n)\s*PORTREVISION\s*\??=\s*)([^\s]+)(.*(?:\n|\z))`)
-)
+type jsonResult struct {
+	Origin string `json:"origin"`
+	Master string `json:"master,omitempty"`
+	Action string `json:"action"`
+	Old    uint64 `json:"old"`
+	New    uint64 `json:"new"`
+	Error  string `json:"error,omitempty"`
+}
 
-func bumpPortrevision(buf []byte) ([]byte, error) {
-	const rev1 = "${1}PORTREVISION=\t1\n"
+// tally counts processed ports by outcome.
+type tally struct {
+	actions map[bump.Action]int
+	// Makefiles restored from backups, in restore mode
+	restored int
+	restore  bool
+	failed   int
+}
 
-	if m := portrevisionRe.FindSubmatch(buf); m != nil {
-		rev, err := strconv.ParseUint(string(m[2]), 10, 64)
-		if err != nil {
-			if err.(*strconv.NumError).Err == strconv.ErrSyntax {
-				return nil, errors.New("not a numeric PORTREVISION")
-			}
-			return nil, err
+// processed returns the number of processed ports.
+func (t tally) processed() int {
+	n := t.failed + t.restored
+	for _, c := range t.actions {
+		n += c
+	}
+	return n
+}
+
+// changed returns the number of changed ports.
+func (t tally) changed() int {
+	n := t.restored
+	for a, c := range t.actions {
+		if a.Changed() {
+			n += c
 		}
-		buf = portrevisionRe.ReplaceAll(buf, []byte(string(m[1])+strconv.FormatUint(rev+1, 10)+string(m[3])))
-	} else if distversionRe.Match(buf) {
-		buf = distversionRe.ReplaceAll(buf, []byte(rev1))
-	} else if portversionRe.Match(buf) {
-		buf = portversionRe.ReplaceAll(buf, []byte(rev1))
 	}
-	return buf, nil
+	return n
 }
 
-var bufPool = sync.Pool{
-	New: func() interface{} {
-		return new(bytes.Buffer)
-	},
+func (t tally) String() string {
+	var sb strings.Builder
+	if t.restore {
+		fmt.Fprintf(&sb, "%d restored, %d skipped, %d error", t.restored, t.actions[bump.Skipped], t.failed)
+		if t.failed != 1 {
+			sb.WriteByte('s')
+		}
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "%d bumped, %d added", t.actions[bump.Bumped], t.actions[bump.Added])
+	if n := t.actions[bump.Removed]; n > 0 {
+		fmt.Fprintf(&sb, ", %d removed", n)
+	}
+	if n := t.actions[bump.Reset]; n > 0 {
+		fmt.Fprintf(&sb, ", %d reset", n)
+	}
+	if n := t.actions[bump.Capped]; n > 0 {
+		fmt.Fprintf(&sb, ", %d capped", n)
+	}
+	fmt.Fprintf(&sb, ", %d skipped, %d error", t.actions[bump.Skipped], t.failed)
+	if t.failed != 1 {
+		sb.WriteByte('s')
+	}
+	return sb.String()
+}
+
+// timing describes processing speed, errors are not counted towards throughput.
+func (t tally) timing(elapsed time.Duration) string {
+	n := t.processed()
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(n-t.failed) / elapsed.Seconds()
+	}
+	prec := time.Millisecond
+	if elapsed < time.Second {
+		prec = time.Microsecond
+	}
+	s := fmt.Sprintf("processed %d origins in %s (%.1f/s", n, elapsed.Round(prec), rate)
+	if t.failed > 0 {
+		s += fmt.Sprintf(" excluding %d failed", t.failed)
+	}
+	return s + ")"
+}
+
+// dryRunVerbs describe what would be done for each bump.Action in dry run mode.
+var dryRunVerbs = [...]string{
+	bump.Skipped: "skip",
+	bump.Bumped:  "bump",
+	bump.Added:   "add",
+	bump.Removed: "remove",
+	bump.Capped:  "skip",
+	bump.Reset:   "reset",
+}
+
+// describeResult returns a verbose description of what was, or with dryRun
+// would be, done to variable name of a successfully processed port.
+func describeResult(res Result, name string, dryRun bool) string {
+	var verb string
+	if dryRun {
+		verb = "would " + dryRunVerbs[res.Action]
+	} else if res.Action == bump.Capped {
+		verb = "skipped"
+	} else {
+		verb = res.Action.String()
+	}
+
+	switch res.Action {
+	case bump.Bumped:
+		return fmt.Sprintf("%s: %s %s %d -> %d", res.Port, verb, name, res.OldRevision, res.NewRevision)
+	case bump.Added:
+		return fmt.Sprintf("%s: %s %s %d", res.Port, verb, name, res.NewRevision)
+	case bump.Removed, bump.Reset:
+		return fmt.Sprintf("%s: %s %s %d", res.Port, verb, name, res.OldRevision)
+	case bump.Capped:
+		return fmt.Sprintf("%s: %s, %s %d -> %d exceeds the maximum", res.Port, verb, name, res.OldRevision, res.NewRevision)
+	}
+	if res.dupOf != "" {
+		return fmt.Sprintf("%s: %s, already processed for %s", res.Port, verb, res.dupOf)
+	}
+	if res.OldRevision > 0 {
+		return fmt.Sprintf("%s: %s, %s %d unchanged", res.Port, verb, name, res.OldRevision)
+	}
+	return fmt.Sprintf("%s: %s, %s", res.Port, verb, res.missing)
 }
 
-func bufGet() *bytes.Buffer {
-	return bufPool.Get().(*bytes.Buffer)
+// processOrigins bumps origins received from origch using pb, prints results
+// and sends the tally of processed ports to donech when done. With -E, cancel
+// is called on the first error.
+func processOrigins(ctx context.Context, cancel context.CancelFunc, pb *PortBumper, origch chan Job, donech chan tally) {
+	t := tally{actions: map[bump.Action]int{}, restore: pb.Restore}
+
+	// output is batched and flushed whenever there are no results ready, so
+	// that it's not delayed while waiting for slow ports
+	out := bufio.NewWriter(os.Stdout)
+	pb.Log.Buffer()
+	flush := func() {
+		prog.clear()
+		out.Flush()
+		pb.Log.Flush()
+	}
+	defer func() {
+		flush()
+		donech <- t
+	}()
+
+	start := time.Now()
+	resch := pb.Process(ctx, origch)
+
+	// origins of changed ports, for the commit message, git add and -o
+	var changed []string
+	collectChanged := commitReason != "" || changedList != nil || gitStage && !pb.DryRun && !pb.Diff
+
+	// a supervisor reading --result-fd wants every result as soon as it's done
+	var enc *json.Encoder
+	if resultFile == os.Stdout {
+		enc = json.NewEncoder(out)
+	} else {
+		enc = json.NewEncoder(resultFile)
+	}
+	pr := &printer{w: out, color: useColor(colorMode, os.Stdout) && !pb.Quiet}
+
+	var tracked *gitTracked
+	if gitCheck {
+		tracked = newGitTracked(pb.Root, pb.makefile())
+	}
+
+	// processing was stopped by -E
+	var failed bool
+
+	printResult := func(res Result) {
+		if res.Err != nil {
+			t.failed++
+			if pb.FailFast && t.failed > pb.MaxFailures && !failed {
+				failed = true
+				cancel()
+			}
+		} else {
+			if res.restored != "" {
+				t.restored++
+			} else {
+				t.actions[res.Action]++
+			}
+			if collectChanged && res.Action.Changed() {
+				changed = append(changed, res.Port)
+			}
+			if state != nil && !pb.DryRun && !pb.Diff && !pb.Query {
+				if err := state.record(res.Origin); err != nil {
+					flush()
+					errExit("error writing state file: %s", err)
+				}
+			}
+		}
+		if resultFile != nil {
+			jr := jsonResult{
+				Origin: res.Origin,
+				Master: res.Master,
+				Action: res.Action.String(),
+				Old:    res.OldRevision,
+				New:    res.NewRevision,
+			}
+			switch {
+			case res.Err != nil:
+				jr.Action = "error"
+				jr.Error = res.Err.Error()
+			case res.restored != "":
+				jr.Action = "restored"
+			}
+			enc.Encode(jr)
+		}
+		if jsonOut {
+			return
+		}
+		if res.Err != nil {
+			pb.Log.Errorf("%s: %s", res.Origin, res.Err)
+			return
+		}
+		if res.restored != "" {
+			switch {
+			case verbose > 0 && pb.DryRun:
+				pr.println(colorGreen, res.Port, ": would restore ", pb.makefile(), " from ", res.restored)
+			case verbose > 0:
+				pr.println(colorGreen, res.Port, ": restored ", pb.makefile(), " from ", res.restored)
+			case pb.DryRun:
+				pr.println(colorGreen, "would restore ", res.Port)
+			case !pb.Quiet:
+				pr.println(colorGreen, res.Port)
+			}
+			return
+		}
+		if quietSkips && res.Action == bump.Skipped && !pb.Query {
+			return
+		}
+		if res.unmatched {
+			pb.Log.Infof("%s: doesn't depend on %s, skipped", res.Origin, strings.Join(pb.Requires, " or "))
+			return
+		}
+		if pb.Query {
+			pr.println(colorNone, res.Origin, " ", res.OldRevision)
+			return
+		}
+		if checkMode {
+			if res.Action.Changed() {
+				pr.println(colorNone, res.Port)
+			}
+			return
+		}
+		if tracked != nil && res.Action.Changed() && !tracked.tracked(res.Port) {
+			pb.Log.Warnf("%s: Makefile is not tracked by git", res.Port)
+		}
+		if res.Master != "" && res.dupOf == "" {
+			if pb.FollowMaster {
+				pb.Log.Infof("%s: bumping master port %s", res.Origin, res.Master)
+			} else {
+				pb.Log.Infof("%s: slave port of %s, bumping it anyway", res.Origin, res.Master)
+			}
+		}
+		if pb.Diff {
+			out.Write(res.Diff)
+			return
+		}
+		if pb.Quiet {
+			return
+		}
+		c := actionColors[res.Action]
+		if verbose > 0 {
+			pr.println(c, describeResult(res, pb.Options.Target(), pb.DryRun))
+			return
+		}
+		// added and removed variables are marked with a + or - suffix
+		switch {
+		case pb.DryRun:
+			pr.println(c, "would ", dryRunVerbs[res.Action], " ", res.Port)
+		case res.Action == bump.Bumped:
+			pr.println(c, res.Port)
+		case res.Action == bump.Added:
+			pr.println(c, res.Port+"+")
+		case res.Action == bump.Removed, res.Action == bump.Reset:
+			pr.println(c, res.Port+"-")
+		case res.Action == bump.Capped:
+			pb.Log.Warnf("%s: %s %d would exceed %d, skipped", res.Origin, pb.Options.Target(), res.NewRevision, pb.Options.Max)
+		case res.dupOf != "" && pb.FollowMaster && res.Master != "":
+			pb.Log.Infof("%s: master port %s already processed, skipped", res.Origin, res.Master)
+		case res.dupOf != "" && pb.FollowMaster:
+			pb.Log.Infof("%s: already processed as a master port, skipped", res.Origin)
+		case res.dupOf != "":
+			pb.Log.Infof("%s: same Makefile as %s, already processed, skipped", res.Origin, res.dupOf)
+		default:
+			pb.Log.Warnf("%s: skipped", res.Origin)
+		}
+	}
+
+	// receive returns the next result, flushing output if it's not ready yet
+	receive := func() (Result, bool) {
+		select {
+		case res, ok := <-resch:
+			return res, ok
+		default:
+			flush()
+			prog.show(t.processed())
+			res, ok := <-resch
+			return res, ok
+		}
+	}
+
+	if ordered {
+		// hold results back until all preceding origins are done
+		pending := map[int]Result{}
+		var next int
+		for res, ok := receive(); ok; res, ok = receive() {
+			pending[res.Index] = res
+			for {
+				r, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				printResult(r)
+				next++
+			}
+		}
+	} else {
+		for res, ok := receive(); ok; res, ok = receive() {
+			printResult(res)
+		}
+	}
+
+	switch {
+	case failed && pb.MaxFailures > 0:
+		pb.Log.Warnf("stopped on %d errors after processing %d origins", t.failed, t.processed())
+	case failed:
+		pb.Log.Warnf("stopped on error after processing %d origins", t.processed())
+	case ctx.Err() != nil:
+		pb.Log.Warnf("interrupted after processing %d origins", t.processed())
+	}
+	if pb.Log.Enabled(LevelInfo) && !pb.Query {
+		// the summary is not a message, don't prefix it
+		pb.Log.Println(t)
+	}
+	if timing || verbose > 0 && pb.Log.Enabled(LevelInfo) {
+		pb.Log.Println(t.timing(time.Since(start)))
+	}
+	if changedList != nil {
+		var w io.Writer = changedList
+		if changedList == os.Stdout {
+			w = out
+		}
+		for _, o := range changed {
+			fmt.Fprintln(w, o)
+		}
+		if changedList != os.Stdout {
+			if err := changedList.Close(); err != nil {
+				pb.Log.Errorf("error writing changed origins list: %s", err)
+				t.failed++
+			}
+		}
+	}
+	if len(changed) > 0 && commitReason != "" {
+		fmt.Fprint(out, commitMessage(commitReason, pb.Options.Target(), changed))
+	}
+	if len(changed) > 0 && gitStage && !pb.DryRun && !pb.Diff {
+		// Makefiles are already modified, just report the error
+		if err := gitAdd(pb.Root, pb.makefile(), changed); err != nil {
+			pb.Log.Errorf("%s", err)
+			t.failed++
+		}
+	}
 }
 
-func bufPut(b *bytes.Buffer) {
-	b.Reset()
-	bufPool.Put(b)
+// commitMessage returns a ports tree style commit message for variable name
+// changes in origins made for reason.
+func commitMessage(reason, name string, origins []string) string {
+	origins = append([]string(nil), origins...)
+	sort.Strings(origins)
+
+	// subject is prefixed with the port, category/* or */*
+	prefix := origins[0]
+	if len(origins) > 1 {
+		cat := path.Dir(origins[0])
+		prefix = cat + "/*"
+		for _, o := range origins[1:] {
+			if path.Dir(o) != cat {
+				prefix = "*/*"
+				break
+			}
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: Bump %s\n\n%s\n", prefix, name, reason)
+	if len(origins) > 1 {
+		sb.WriteString("\nAffected ports:\n")
+		for _, o := range origins {
+			fmt.Fprintf(&sb, "  %s\n", o)
+		}
+	}
+	return sb.String()
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/dmgk/portbump/bump"
+)
+
+// ANSI terminal colors
+const (
+	colorNone    = ""
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorMagenta = "\x1b[35m"
+	colorCyan    = "\x1b[36m"
+	colorReset   = "\x1b[0m"
+)
+
+// actionColors are colors of results by action.
+var actionColors = [...]string{
+	bump.Skipped: colorYellow,
+	bump.Bumped:  colorGreen,
+	bump.Added:   colorCyan,
+	bump.Removed: colorMagenta,
+	bump.Capped:  colorYellow,
+	bump.Reset:   colorMagenta,
+}
+
+// useColor reports whether output to f should be colored according to mode,
+// one of auto, always or never. In auto mode only terminals are colored.
+func useColor(mode string, f *os.File) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	default:
+		return isTerminal(f)
+	}
+}
+
+// isTerminal reports whether f is a character device, most likely a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// isPipe reports whether f is a pipe or a regular file, something that
+// reading till the end of won't block on, unlike a terminal or a socket.
+func isPipe(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && (fi.Mode()&os.ModeNamedPipe != 0 || fi.Mode().IsRegular())
+}
+
+// printer writes result lines to w, colored if color is true.
+type printer struct {
+	w     io.Writer
+	color bool
+}
+
+// println writes a line made of a to p.w in color c.
+func (p *printer) println(c string, a ...any) {
+	io.WriteString(p.w, colorize(fmt.Sprint(a...), c, p.color)+"\n")
+}
+
+func colorize(s, c string, enabled bool) string {
+	if !enabled || c == colorNone {
+		return s
+	}
+	return c + s + colorReset
+}
+
+// progress shows a "done/total processed" line on a terminal, updated in
+// place. Nothing is shown until the total is known and a nil progress shows
+// nothing at all.
+type progress struct {
+	w     io.Writer
+	total atomic.Int64
+	// the line is currently shown
+	shown bool
+}
+
+// setTotal sets the number of origins to process, it's safe to call while
+// the line is being shown.
+func (p *progress) setTotal(n int) {
+	if p != nil {
+		p.total.Store(int64(n))
+	}
+}
+
+// show shows the line with done origins processed.
+func (p *progress) show(done int) {
+	if p == nil {
+		return
+	}
+	total := p.total.Load()
+	if total == 0 {
+		return
+	}
+	fmt.Fprintf(p.w, "\r%d/%d processed", done, total)
+	p.shown = true
+}
+
+// clear erases the line, if it's shown, before other output.
+func (p *progress) clear() {
+	if p == nil || !p.shown {
+		return
+	}
+	io.WriteString(p.w, "\r\x1b[K")
+	p.shown = false
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// chown is a no-op on systems without unix file ownership.
+func chown(f *os.File, fi os.FileInfo) error {
+	return nil
+}
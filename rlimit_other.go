@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// openFilesLimit returns 0, the open files limit is unknown on systems
+// without unix rlimits.
+func openFilesLimit() int {
+	return 0
+}
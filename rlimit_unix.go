@@ -0,0 +1,19 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// openFilesLimit returns the soft limit on the number of open files, or 0 if
+// it's unknown or unlimited.
+func openFilesLimit() int {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0
+	}
+	// RLIM_INFINITY differs between systems, but it's always huge
+	if rl.Cur > 1<<20 {
+		return 0
+	}
+	return int(rl.Cur)
+}
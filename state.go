@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bufio"
+	"os"
+)
+
+// stateFile records origins of successfully processed ports, so that
+// interrupted runs can be resumed without bumping ports again.
+type stateFile struct {
+	f    *os.File
+	done map[string]bool
+}
+
+// openState opens the state file at path for appending, creating it if
+// necessary, and loads origins already recorded in it.
+func openState(path string) (*stateFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	done := map[string]bool{}
+	err = scanOrigins(f, bufio.ScanLines, func(o string) bool {
+		done[o] = true
+		return true
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &stateFile{f, done}, nil
+}
+
+// record appends origin to the state file. It's written unbuffered, so every
+// recorded origin survives the program being killed.
+func (s *stateFile) record(origin string) error {
+	_, err := s.f.WriteString(origin + "\n")
+	return err
+}
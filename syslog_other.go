@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// openSyslog returns an error, there is no system logger on systems other
+// than unix.
+func openSyslog(tag string) (sysLogger, error) {
+	return nil, errors.New("syslog is not supported on this system")
+}
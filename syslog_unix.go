@@ -0,0 +1,10 @@
+//go:build unix
+
+package main
+
+import "log/syslog"
+
+// openSyslog connects to the system logger, tagging messages with tag.
+func openSyslog(tag string) (sysLogger, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_USER, tag)
+}